@@ -0,0 +1,264 @@
+package chttp
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"net/url"
+	"strings"
+)
+
+var ErrInvalidToken = errors.New("invalid token")
+
+type principalKey struct{}
+
+// authenticated user of the request
+type Principal struct {
+	// user name, token subject or api key owner
+	Name string
+	// authentication scheme, e.g. "Basic", "Bearer" or "APIKey"
+	Scheme string
+	// extra data from the verifier, such as roles or token claims
+	Attributes map[string]any
+}
+
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(*Principal)
+	return principal, ok
+}
+
+// get authenticated user, it will be nil if the request is not authenticated
+func (c Context) Principal() *Principal {
+	principal, _ := PrincipalFromContext(c.Context)
+	return principal
+}
+
+// verify a bearer token or api key, return an error that wraps [ErrInvalidToken] if it is not valid.
+// other error means the token can not be verified, e.g. the key store is down, and it is answered with 500
+type TokenVerifier func(ctx context.Context, token string) (*Principal, error)
+
+type BasicAuthOption struct {
+	Realm string
+	// static credentials, username as the key and password as the value
+	Users map[string]string
+	// custom credentials check, it is used when the user is not found in Users
+	Validator func(ctx context.Context, username, password string) (*Principal, bool)
+}
+
+// authenticate with HTTP Basic scheme (RFC 7617), password is compared in constant time
+func BasicAuthMiddleware(option BasicAuthOption) Middleware {
+	realm := option.Realm
+	if realm == "" {
+		realm = "Restricted"
+	}
+
+	challenge := `Basic realm="` + escapeQuoted(realm) + `", charset="UTF-8"`
+
+	return func(next Handler) Handler {
+		return func(c Context) *Response {
+			credentials, ok := authorizationCredentials(c.Request, "Basic")
+			if !ok {
+				return unauthorized(challenge)
+			}
+
+			decoded, err := base64.StdEncoding.DecodeString(credentials)
+			if err != nil {
+				return unauthorized(challenge)
+			}
+
+			username, password, ok := strings.Cut(string(decoded), ":")
+			if !ok {
+				return unauthorized(challenge)
+			}
+
+			var principal *Principal
+			if expected, found := option.Users[username]; found {
+				if secureCompare(password, expected) {
+					principal = &Principal{Name: username, Scheme: "Basic"}
+				}
+			} else {
+				// keep the timing similar for unknown user
+				secureCompare(password, username)
+
+				if option.Validator != nil {
+					principal, ok = option.Validator(c, username, password)
+					if !ok {
+						principal = nil
+					}
+				}
+			}
+
+			if principal == nil {
+				return unauthorized(challenge)
+			}
+
+			c.Context = WithPrincipal(c.Context, principal)
+
+			return next(c)
+		}
+	}
+}
+
+type BearerAuthOption struct {
+	Realm    string
+	Verifier TokenVerifier
+}
+
+// authenticate with Bearer token (RFC 6750), the token is checked by the verifier
+func BearerAuthMiddleware(option BearerAuthOption) Middleware {
+	if option.Verifier == nil {
+		panic("bearer auth verifier is required")
+	}
+
+	challenge := "Bearer"
+	if option.Realm != "" {
+		challenge += ` realm="` + escapeQuoted(option.Realm) + `"`
+	}
+
+	return func(next Handler) Handler {
+		return func(c Context) *Response {
+			token, ok := authorizationCredentials(c.Request, "Bearer")
+			if !ok || token == "" {
+				return unauthorized(challenge)
+			}
+
+			principal, err := option.Verifier(c, token)
+			if err != nil && !errors.Is(err, ErrInvalidToken) {
+				return verifierError(c, err)
+			}
+
+			// the detail of the error is not sent to the client
+			if err != nil || principal == nil {
+				slog.DebugContext(c, "Invalid bearer token", "err", err)
+
+				return unauthorized(challenge + errorParams(challenge, "invalid_token", ErrInvalidToken.Error()))
+			}
+
+			if principal.Scheme == "" {
+				principal.Scheme = "Bearer"
+			}
+
+			c.Context = WithPrincipal(c.Context, principal)
+
+			return next(c)
+		}
+	}
+}
+
+type APIKeyOption struct {
+	Realm string
+	// header that carry the key, default is X-API-Key
+	Header string
+	// query argument that carry the key, it is only checked if the header is empty
+	Query string
+	// static keys, api key as the key and the owner name as the value
+	Keys map[string]string
+	// custom key check, it is used when the key is not found in Keys
+	Verifier TokenVerifier
+}
+
+// authenticate with api key from a header or a query argument
+func APIKeyMiddleware(option APIKeyOption) Middleware {
+	header := option.Header
+	if header == "" {
+		header = "X-API-Key"
+	}
+
+	challenge := `APIKey header="` + escapeQuoted(header) + `"`
+	if option.Realm != "" {
+		challenge = `APIKey realm="` + escapeQuoted(option.Realm) + `", header="` + escapeQuoted(header) + `"`
+	}
+
+	return func(next Handler) Handler {
+		return func(c Context) *Response {
+			key := c.Request.GetHeader(header)
+			if key == "" && option.Query != "" {
+				key, _ = url.QueryUnescape(c.Request.GetArgs(option.Query))
+			}
+
+			if key == "" {
+				return unauthorized(challenge)
+			}
+
+			var principal *Principal
+			for expected, owner := range option.Keys {
+				if secureCompare(key, expected) {
+					principal = &Principal{Name: owner, Scheme: "APIKey"}
+				}
+			}
+
+			if principal == nil && option.Verifier != nil {
+				verified, err := option.Verifier(c, key)
+				if err != nil && !errors.Is(err, ErrInvalidToken) {
+					return verifierError(c, err)
+				}
+
+				if err == nil && verified != nil {
+					principal = verified
+					if principal.Scheme == "" {
+						principal.Scheme = "APIKey"
+					}
+				}
+			}
+
+			if principal == nil {
+				return unauthorized(challenge + errorParams(challenge, "invalid_key", "invalid api key"))
+			}
+
+			c.Context = WithPrincipal(c.Context, principal)
+
+			return next(c)
+		}
+	}
+}
+
+// get credentials from the Authorization header with the scheme, the scheme is case-insensitive
+func authorizationCredentials(req *Request, scheme string) (string, bool) {
+	authorization := strings.TrimSpace(req.GetHeader("Authorization"))
+
+	prefix, credentials, ok := strings.Cut(authorization, " ")
+	if !ok || !strings.EqualFold(prefix, scheme) {
+		return "", false
+	}
+
+	return strings.TrimSpace(credentials), true
+}
+
+func unauthorized(challenge string) *Response {
+	return NewTextResponse("401 Unauthorized").SetCode(401).SetHeader("WWW-Authenticate", challenge)
+}
+
+func verifierError(c Context, err error) *Response {
+	slog.ErrorContext(c, "Error verifying token", "err", err)
+
+	return NewTextResponse("500 Internal Server Error").SetCode(500)
+}
+
+func errorParams(challenge, code, description string) string {
+	separator := ", "
+	if !strings.Contains(challenge, " ") {
+		separator = " "
+	}
+
+	return separator + `error="` + code + `", error_description="` + escapeQuoted(description) + `"`
+}
+
+// compare the hash of both values so the length is not leaked
+func secureCompare(given, expected string) bool {
+	a := sha256.Sum256([]byte(given))
+	b := sha256.Sum256([]byte(expected))
+
+	return subtle.ConstantTimeCompare(a[:], b[:]) == 1
+}
+
+func escapeQuoted(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return strings.ReplaceAll(s, `"`, `\"`)
+}
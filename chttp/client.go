@@ -0,0 +1,407 @@
+package chttp
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	ErrTooManyRedirects = errors.New("too many redirects")
+	ErrResponseTooLarge = errors.New("response is too large")
+)
+
+// HTTP/1.1 client that send [Request] and read [Response], the connections are kept alive
+// and reused for the same host. the zero value is ready to use
+type Client struct {
+	// deadline for the whole request including redirects, zero means no timeout
+	Timeout time.Duration
+	// default is 10 seconds
+	DialTimeout time.Duration
+	// default is 4
+	MaxIdleConnsPerHost int
+	// idle connection is closed after the timeout, default is 90 seconds
+	IdleConnTimeout time.Duration
+	// default is 10, negative value will return the redirect response instead of following it
+	MaxRedirects int
+	// default is 10 MB
+	MaxResponseBodySize int
+	// used to connect https hosts
+	TLSConfig *tls.Config
+
+	mu   sync.Mutex
+	idle map[string][]*clientConn
+}
+
+type clientConn struct {
+	key    string
+	conn   net.Conn
+	br     *bufio.Reader
+	idleAt time.Time
+}
+
+func NewClient() *Client {
+	return &Client{}
+}
+
+// create a request for [Client.Do], the url must be absolute
+func NewClientRequest(method, rawURL, body string) (*Request, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.New("url must be absolute http or https url: " + rawURL)
+	}
+
+	if method == "" {
+		method = "GET"
+	}
+
+	req := &Request{
+		Method:  strings.ToUpper(method),
+		Version: "HTTP/1.1",
+		Body:    body,
+		Headers: map[string]string{"Host": u.Host},
+		URL:     u,
+	}
+	req.Path, req.Args = parseArgs(u.RequestURI())
+
+	return req, nil
+}
+
+func (c *Client) Get(ctx context.Context, url string) (*Response, error) {
+	req, err := NewClientRequest("GET", url, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Do(ctx, req)
+}
+
+func (c *Client) Post(ctx context.Context, url, contentType, body string) (*Response, error) {
+	req, err := NewClientRequest("POST", url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Headers["Content-Type"] = contentType
+
+	return c.Do(ctx, req)
+}
+
+// send the request and read the whole response body into [Response.Body], redirects are followed.
+// [ErrTooManyRedirects] is returned with the last redirect response
+func (c *Client) Do(ctx context.Context, req *Request) (*Response, error) {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	maxRedirects := c.MaxRedirects
+	if maxRedirects == 0 {
+		maxRedirects = 10
+	}
+
+	for redirects := 0; ; redirects++ {
+		resp, err := c.send(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+
+		location := resp.Headers["Location"]
+		if maxRedirects < 0 || location == "" || !isRedirect(resp.Code) {
+			return resp, nil
+		}
+
+		if redirects >= maxRedirects {
+			return resp, ErrTooManyRedirects
+		}
+
+		req, err = redirectRequest(req, resp.Code, location)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// close every idle connection, active connections are not affected
+func (c *Client) CloseIdleConnections() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, conns := range c.idle {
+		for _, cc := range conns {
+			cc.conn.Close()
+		}
+	}
+	c.idle = nil
+}
+
+func (c *Client) send(ctx context.Context, req *Request) (*Response, error) {
+	if req.URL == nil || !req.URL.IsAbs() {
+		return nil, errors.New("request url must be absolute, use NewClientRequest")
+	}
+
+	for attempt := 0; ; attempt++ {
+		cc, reused, err := c.getConn(ctx, req.URL)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := c.roundTrip(ctx, cc, req)
+
+		// the server may close an idle connection at any time, retry once on a new connection
+		if err != nil && reused && attempt == 0 && ctx.Err() == nil && canRetry(req, err) {
+			continue
+		}
+
+		return resp, err
+	}
+}
+
+func (c *Client) roundTrip(ctx context.Context, cc *clientConn, req *Request) (*Response, error) {
+	// close the connection to unblock read and write when ctx is done
+	stop := context.AfterFunc(ctx, func() { cc.conn.Close() })
+
+	deadline, _ := ctx.Deadline()
+	cc.conn.SetDeadline(deadline)
+
+	out := *req
+	out.Headers = make(map[string]string, len(req.Headers)+1)
+	for key, value := range req.Headers {
+		out.Headers[key] = value
+	}
+
+	if getHeader(out.Headers, "Host") == "" {
+		out.Headers["Host"] = req.URL.Host
+	}
+
+	fail := func(err error) (*Response, error) {
+		stop()
+		cc.conn.Close()
+
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		return nil, err
+	}
+
+	if err := out.Write(cc.conn); err != nil {
+		return fail(err)
+	}
+
+	resp, keepAlive, err := readResponseHead(cc.br)
+	if err != nil {
+		return fail(err)
+	}
+
+	body, err := responseBodyReader(cc.br, resp, req.Method)
+	if err != nil {
+		return fail(err)
+	}
+
+	if body != nil {
+		maxSize := c.MaxResponseBodySize
+		if maxSize <= 0 {
+			maxSize = 10 << 20
+		}
+
+		b, err := io.ReadAll(io.LimitReader(body, int64(maxSize)+1))
+		if err != nil {
+			return fail(err)
+		}
+
+		if len(b) > maxSize {
+			return fail(ErrResponseTooLarge)
+		}
+		resp.Body = string(b)
+
+		// the body is delimited by closing the connection
+		if body == io.Reader(cc.br) {
+			keepAlive = false
+		}
+	}
+
+	// the body is already decoded
+	delete(resp.Headers, "Transfer-Encoding")
+
+	if !stop() || !keepAlive || getHeader(out.Headers, "Connection") == "close" {
+		cc.conn.Close()
+		return resp, nil
+	}
+
+	cc.conn.SetDeadline(time.Time{})
+	c.putConn(cc)
+
+	return resp, nil
+}
+
+func (c *Client) getConn(ctx context.Context, u *url.URL) (*clientConn, bool, error) {
+	addr := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+		addr = net.JoinHostPort(u.Hostname(), port)
+	}
+	key := u.Scheme + "://" + addr
+
+	idleTimeout := c.IdleConnTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = 90 * time.Second
+	}
+
+	c.mu.Lock()
+	conns := c.idle[key]
+	for len(conns) > 0 {
+		cc := conns[len(conns)-1]
+		conns = conns[:len(conns)-1]
+
+		if time.Since(cc.idleAt) > idleTimeout {
+			cc.conn.Close()
+			continue
+		}
+
+		c.idle[key] = conns
+		c.mu.Unlock()
+
+		return cc, true, nil
+	}
+	delete(c.idle, key)
+	c.mu.Unlock()
+
+	dialTimeout := c.DialTimeout
+	if dialTimeout <= 0 {
+		dialTimeout = 10 * time.Second
+	}
+	dialer := &net.Dialer{Timeout: dialTimeout}
+
+	var conn net.Conn
+	var err error
+	if u.Scheme == "https" {
+		config := &tls.Config{}
+		if c.TLSConfig != nil {
+			config = c.TLSConfig.Clone()
+		}
+
+		if config.ServerName == "" {
+			config.ServerName = u.Hostname()
+		}
+		config.NextProtos = []string{"http/1.1"}
+
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: config}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	return &clientConn{key: key, conn: conn, br: bufio.NewReader(conn)}, false, nil
+}
+
+func (c *Client) putConn(cc *clientConn) {
+	maxIdle := c.MaxIdleConnsPerHost
+	if maxIdle <= 0 {
+		maxIdle = 4
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.idle[cc.key]) >= maxIdle {
+		cc.conn.Close()
+		return
+	}
+
+	if c.idle == nil {
+		c.idle = make(map[string][]*clientConn)
+	}
+
+	cc.idleAt = time.Now()
+	c.idle[cc.key] = append(c.idle[cc.key], cc)
+}
+
+// the request is retried only if the connection is closed by the server before the
+// response, and it is safe to send again
+func canRetry(req *Request, err error) bool {
+	if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) &&
+		!errors.Is(err, syscall.ECONNRESET) && !errors.Is(err, syscall.EPIPE) {
+		return false
+	}
+
+	switch req.Method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+
+	return req.Body == ""
+}
+
+func isRedirect(code int) bool {
+	switch code {
+	case 301, 302, 303, 307, 308:
+		return true
+	}
+
+	return false
+}
+
+// create the next request of the redirect, 307 and 308 keep the method and the body
+func redirectRequest(req *Request, code int, location string) (*Request, error) {
+	u, err := req.URL.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.New("unsupported redirect scheme: " + strconv.Quote(u.Scheme))
+	}
+
+	next := &Request{
+		Method:  req.Method,
+		Version: req.Version,
+		Body:    req.Body,
+		Headers: make(map[string]string, len(req.Headers)),
+		Cookie:  req.Cookie,
+		URL:     u,
+	}
+	next.Path, next.Args = parseArgs(u.RequestURI())
+
+	for key, value := range req.Headers {
+		next.Headers[key] = value
+	}
+
+	if (code == 303 && req.Method != "HEAD") || ((code == 301 || code == 302) && req.Method == "POST") {
+		next.Method = "GET"
+		next.Body = ""
+		deleteHeader(next.Headers, "Content-Type")
+	}
+
+	// dont leak credentials to another host
+	if u.Host != req.URL.Host {
+		deleteHeader(next.Headers, "Authorization")
+		deleteHeader(next.Headers, "Proxy-Authorization")
+		deleteHeader(next.Headers, "Cookie")
+		next.Cookie = nil
+	}
+
+	deleteHeader(next.Headers, "Host")
+	next.Headers["Host"] = u.Host
+
+	return next, nil
+}
@@ -0,0 +1,164 @@
+package chttp
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// connWatcher detect client disconnect by reading the connection in background
+// while the handler is running. bytes that is read by the watcher will be returned
+// on the next Read, so the handler can still read the connection (e.g. websocket).
+// the watcher stops when it has buffered [maxWatchBuffer] bytes, the disconnect is
+// not detected after that until the handler reads the connection
+type connWatcher struct {
+	net.Conn
+	cancel func()
+
+	mu       sync.Mutex
+	done     chan struct{}
+	stopOnce sync.Once
+	stopping bool
+	buf      []byte
+	err      error
+	// read deadline that is set through the watcher, it is restored after the watcher is stopped
+	deadline time.Time
+}
+
+// max bytes that is buffered by the watcher, e.g. pipelined requests
+const maxWatchBuffer = 64 << 10
+
+func watchConn(conn net.Conn, cancel func()) *connWatcher {
+	w := &connWatcher{
+		Conn:   conn,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	go w.watch()
+
+	return w
+}
+
+func (w *connWatcher) watch() {
+	defer close(w.done)
+
+	buf := make([]byte, 4096)
+	for {
+		n, err := w.Conn.Read(buf)
+
+		w.mu.Lock()
+		w.buf = append(w.buf, buf[:n]...)
+		full := len(w.buf) >= maxWatchBuffer
+
+		// deadline is set by stop or by the handler, so it is not a disconnect
+		if err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+			w.err = err
+			w.cancel()
+		}
+		w.mu.Unlock()
+
+		if err != nil || full {
+			return
+		}
+	}
+}
+
+// stop the background read, it is safe to call stop multiple times
+func (w *connWatcher) stop() {
+	w.stopOnce.Do(func() {
+		// unblock the background read
+		w.mu.Lock()
+		w.stopping = true
+		w.Conn.SetReadDeadline(time.Now())
+		w.mu.Unlock()
+
+		<-w.done
+
+		w.mu.Lock()
+		w.stopping = false
+		w.Conn.SetReadDeadline(w.deadline)
+		w.mu.Unlock()
+	})
+}
+
+func (w *connWatcher) Read(p []byte) (int, error) {
+	w.stop()
+
+	w.mu.Lock()
+	if len(w.buf) > 0 {
+		n := copy(p, w.buf)
+		w.buf = w.buf[n:]
+		w.mu.Unlock()
+
+		return n, nil
+	}
+
+	err := w.err
+	w.mu.Unlock()
+
+	if err != nil {
+		return 0, err
+	}
+
+	return w.Conn.Read(p)
+}
+
+func (w *connWatcher) SetReadDeadline(t time.Time) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.deadline = t
+
+	// it will be set by stop after the background read is unblocked
+	if w.stopping {
+		return nil
+	}
+
+	return w.Conn.SetReadDeadline(t)
+}
+
+func (w *connWatcher) SetDeadline(t time.Time) error {
+	if err := w.Conn.SetWriteDeadline(t); err != nil {
+		return err
+	}
+
+	return w.SetReadDeadline(t)
+}
+
+// connection that return the buffered bytes before reading the connection,
+// it is used when the first bytes are read to detect the protocol
+type bufferedConn struct {
+	net.Conn
+	buf []byte
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	if len(c.buf) > 0 {
+		n := copy(p, c.buf)
+		c.buf = c.buf[n:]
+
+		return n, nil
+	}
+
+	return c.Conn.Read(p)
+}
+
+// get client ip address from the connection, it will be empty if the connection doesnt have a remote address.
+// proxy headers such as X-Forwarded-For are not trusted
+func (c Context) ClientIP() string {
+	conn, ok := c.Conn.(interface{ RemoteAddr() net.Addr })
+	if !ok || conn.RemoteAddr() == nil {
+		return ""
+	}
+
+	addr := conn.RemoteAddr().String()
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+
+	return host
+}
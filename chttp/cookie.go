@@ -0,0 +1,146 @@
+package chttp
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+type SameSite int
+
+const (
+	SameSiteDefault SameSite = iota
+	SameSiteLax
+	SameSiteStrict
+	SameSiteNone
+)
+
+type Cookie struct {
+	Name   string
+	Value  string
+	Path   string
+	Domain string
+	// zero time means the Expires attribute is not set
+	Expires time.Time
+	// zero means the Max-Age attribute is not set, negative value will delete the cookie
+	MaxAge   int
+	Secure   bool
+	HttpOnly bool
+	SameSite SameSite
+}
+
+// format cookie as Set-Cookie header value
+func (c Cookie) String() string {
+	var b strings.Builder
+
+	b.WriteString(sanitizeCookieName(c.Name) + "=" + sanitizeCookieValue(c.Value))
+
+	if c.Path != "" {
+		b.WriteString("; Path=" + sanitizeCookieValue(c.Path))
+	}
+
+	if c.Domain != "" {
+		b.WriteString("; Domain=" + sanitizeCookieValue(strings.TrimPrefix(c.Domain, ".")))
+	}
+
+	if !c.Expires.IsZero() {
+		b.WriteString("; Expires=" + c.Expires.UTC().Format("Mon, 02 Jan 2006 15:04:05 GMT"))
+	}
+
+	if c.MaxAge > 0 {
+		b.WriteString("; Max-Age=" + strconv.Itoa(c.MaxAge))
+	} else if c.MaxAge < 0 {
+		b.WriteString("; Max-Age=0")
+	}
+
+	if c.HttpOnly {
+		b.WriteString("; HttpOnly")
+	}
+
+	if c.Secure {
+		b.WriteString("; Secure")
+	}
+
+	switch c.SameSite {
+	case SameSiteLax:
+		b.WriteString("; SameSite=Lax")
+	case SameSiteStrict:
+		b.WriteString("; SameSite=Strict")
+	case SameSiteNone:
+		b.WriteString("; SameSite=None")
+	}
+
+	return b.String()
+}
+
+// parse a Set-Cookie header value, unknown attributes are ignored
+func parseSetCookie(line string) (Cookie, bool) {
+	parts := strings.Split(line, ";")
+
+	name, value, ok := strings.Cut(strings.TrimSpace(parts[0]), "=")
+	if !ok || name == "" {
+		return Cookie{}, false
+	}
+
+	cookie := Cookie{
+		Name:  name,
+		Value: strings.Trim(value, `"`),
+	}
+
+	for _, part := range parts[1:] {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+
+		switch strings.ToLower(key) {
+		case "path":
+			cookie.Path = value
+		case "domain":
+			cookie.Domain = value
+		case "expires":
+			if expires, err := time.Parse("Mon, 02 Jan 2006 15:04:05 GMT", value); err == nil {
+				cookie.Expires = expires
+			}
+		case "max-age":
+			if maxAge, err := strconv.Atoi(value); err == nil {
+				if maxAge <= 0 {
+					maxAge = -1
+				}
+				cookie.MaxAge = maxAge
+			}
+		case "secure":
+			cookie.Secure = true
+		case "httponly":
+			cookie.HttpOnly = true
+		case "samesite":
+			switch strings.ToLower(value) {
+			case "lax":
+				cookie.SameSite = SameSiteLax
+			case "strict":
+				cookie.SameSite = SameSiteStrict
+			case "none":
+				cookie.SameSite = SameSiteNone
+			}
+		}
+	}
+
+	return cookie, true
+}
+
+func sanitizeCookieName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r <= 0x20 || r >= 0x7f || strings.ContainsRune("()<>@,;:\\\"/[]?={}", r) {
+			return -1
+		}
+
+		return r
+	}, name)
+}
+
+func sanitizeCookieValue(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r <= 0x20 || r >= 0x7f || r == '"' || r == ';' || r == '\\' || r == ',' {
+			return -1
+		}
+
+		return r
+	}, value)
+}
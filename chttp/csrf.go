@@ -0,0 +1,274 @@
+package chttp
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"html/template"
+	"net/url"
+	"strings"
+)
+
+const csrfSessionKey = "_csrf_token"
+
+const csrfTokenLength = 32
+
+type csrfKey struct{}
+
+type csrfState struct {
+	token     string
+	fieldName string
+}
+
+type CSRFOption struct {
+	// cookie that keep the token for double submit cookie, it is not used if the session is available
+	CookieName string
+	// header that carry the token, used by javascript clients
+	HeaderName string
+	// form field that carry the token, see [CSRFField]
+	FieldName string
+	// other origins that is allowed to send unsafe request, e.g. "https://admin.example.com"
+	TrustedOrigins []string
+	// route patterns or paths that is not checked, e.g. webhooks
+	Exempt   []string
+	Secure   bool
+	SameSite SameSite
+	// response when the check failed, default is 403 Forbidden
+	FailureHandler Handler
+}
+
+var DefaultCSRFOption = CSRFOption{
+	CookieName: "csrf_token",
+	HeaderName: "X-CSRF-Token",
+	FieldName:  "csrf_token",
+	SameSite:   SameSiteLax,
+}
+
+// protect unsafe methods from cross-site request forgery. the token is kept in the session
+// (synchronizer token) if [SessionManager.Middleware] is used before it, otherwise in a cookie
+// (double submit cookie). Origin, Referer and Sec-Fetch-Site are also checked on unsafe methods
+func CSRFMiddleware(option *CSRFOption) Middleware {
+	if option == nil {
+		option = &DefaultCSRFOption
+	}
+
+	o := *option
+	if o.CookieName == "" {
+		o.CookieName = DefaultCSRFOption.CookieName
+	}
+
+	if o.HeaderName == "" {
+		o.HeaderName = DefaultCSRFOption.HeaderName
+	}
+
+	if o.FieldName == "" {
+		o.FieldName = DefaultCSRFOption.FieldName
+	}
+
+	if o.FailureHandler == nil {
+		o.FailureHandler = func(c Context) *Response {
+			return NewTextResponse("403 Forbidden").SetCode(403)
+		}
+	}
+
+	trusted := make(map[string]struct{}, len(o.TrustedOrigins))
+	for _, origin := range o.TrustedOrigins {
+		trusted[strings.ToLower(strings.TrimSuffix(origin, "/"))] = struct{}{}
+	}
+
+	exempt := make(map[string]struct{}, len(o.Exempt))
+	for _, path := range o.Exempt {
+		exempt[path] = struct{}{}
+	}
+
+	return func(next Handler) Handler {
+		return func(c Context) *Response {
+			if _, ok := exempt[c.Pattern]; ok {
+				return next(c)
+			}
+
+			if _, ok := exempt[c.Request.Path]; ok {
+				return next(c)
+			}
+
+			session := c.Session()
+
+			secret, isNew := csrfSecret(c, session, o.CookieName)
+
+			if !isSafeMethod(c.Request.Method) {
+				if !checkOrigin(c.Request, trusted) {
+					return o.FailureHandler(c)
+				}
+
+				if isNew || !validCSRFToken(csrfRequestToken(c.Request, o), secret) {
+					return o.FailureHandler(c)
+				}
+			}
+
+			c.Context = context.WithValue(c.Context, csrfKey{}, &csrfState{
+				token:     maskCSRFToken(secret),
+				fieldName: o.FieldName,
+			})
+
+			resp := next(c)
+			if resp == nil {
+				resp = NewResponse()
+			}
+
+			if isNew && session == nil {
+				resp.AddCookie(Cookie{
+					Name:     o.CookieName,
+					Value:    base64.RawURLEncoding.EncodeToString(secret),
+					Path:     "/",
+					Secure:   o.Secure,
+					SameSite: o.SameSite,
+				})
+			}
+
+			return resp
+		}
+	}
+}
+
+// get the masked csrf token, a new mask is used for every request to prevent BREACH attack
+func (c Context) CSRFToken() string {
+	state, ok := c.Value(csrfKey{}).(*csrfState)
+	if !ok {
+		return ""
+	}
+
+	return state.token
+}
+
+// hidden input that carry the csrf token, use it inside a form in html/template
+func CSRFField(c Context) template.HTML {
+	state, ok := c.Value(csrfKey{}).(*csrfState)
+	if !ok {
+		return ""
+	}
+
+	return template.HTML(`<input type="hidden" name="` + template.HTMLEscapeString(state.fieldName) +
+		`" value="` + template.HTMLEscapeString(state.token) + `">`)
+}
+
+// template functions "csrfToken" and "csrfField" for the request
+func CSRFTemplateFuncs(c Context) template.FuncMap {
+	return template.FuncMap{
+		"csrfToken": c.CSRFToken,
+		"csrfField": func() template.HTML { return CSRFField(c) },
+	}
+}
+
+// get the secret from the session or the cookie, a new secret is generated if it doesnt exist
+func csrfSecret(c Context, session *Session, cookieName string) ([]byte, bool) {
+	var encoded string
+	if session != nil {
+		encoded = session.GetString(csrfSessionKey)
+	} else if c.Request.Cookie != nil {
+		encoded = c.Request.Cookie[cookieName]
+	}
+
+	secret, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err == nil && len(secret) == csrfTokenLength {
+		return secret, false
+	}
+
+	secret = randomBytes(csrfTokenLength)
+	if session != nil {
+		session.Set(csrfSessionKey, base64.RawURLEncoding.EncodeToString(secret))
+	}
+
+	return secret, true
+}
+
+func csrfRequestToken(req *Request, option CSRFOption) string {
+	if token := req.GetHeader(option.HeaderName); token != "" {
+		return token
+	}
+
+	if strings.HasPrefix(req.GetHeader("Content-Type"), "application/x-www-form-urlencoded") {
+		form, err := url.ParseQuery(req.Body)
+		if err == nil {
+			return form.Get(option.FieldName)
+		}
+	}
+
+	return ""
+}
+
+// token is a random one time pad followed by the secret xor with the pad
+func maskCSRFToken(secret []byte) string {
+	pad := randomBytes(len(secret))
+	token := make([]byte, len(secret)*2)
+	copy(token, pad)
+
+	for i := range secret {
+		token[len(secret)+i] = secret[i] ^ pad[i]
+	}
+
+	return base64.RawURLEncoding.EncodeToString(token)
+}
+
+func validCSRFToken(token string, secret []byte) bool {
+	decoded, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(decoded) != csrfTokenLength*2 {
+		return false
+	}
+
+	unmasked := make([]byte, csrfTokenLength)
+	for i := range unmasked {
+		unmasked[i] = decoded[i] ^ decoded[csrfTokenLength+i]
+	}
+
+	return subtle.ConstantTimeCompare(unmasked, secret) == 1
+}
+
+// reject cross-site request with Sec-Fetch-Site, Origin or Referer from another host
+func checkOrigin(req *Request, trusted map[string]struct{}) bool {
+	host := strings.ToLower(req.GetHeader("Host"))
+
+	origin := req.GetHeader("Origin")
+	if origin == "" {
+		if referer := req.GetHeader("Referer"); referer != "" {
+			u, err := url.Parse(referer)
+			if err != nil {
+				return false
+			}
+			origin = u.Scheme + "://" + u.Host
+		}
+	}
+
+	originTrusted := false
+	if origin != "" {
+		if origin == "null" {
+			return false
+		}
+
+		u, err := url.Parse(origin)
+		if err != nil || u.Host == "" {
+			return false
+		}
+
+		_, isTrusted := trusted[strings.ToLower(u.Scheme+"://"+u.Host)]
+		if !isTrusted && strings.ToLower(u.Host) != host {
+			return false
+		}
+		originTrusted = isTrusted
+	}
+
+	switch strings.ToLower(req.GetHeader("Sec-Fetch-Site")) {
+	case "cross-site", "same-site":
+		return originTrusted
+	}
+
+	return true
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+		return true
+	}
+
+	return false
+}
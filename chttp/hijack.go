@@ -0,0 +1,34 @@
+package chttp
+
+import (
+	"context"
+	"io"
+)
+
+// take over the connection after resp is written, resp is usually 101 Switching Protocols.
+// fn can read and write the connection directly after the routing and the middlewares are done,
+// the connection is closed when fn returns or the server is shut down. HTTP/2 is not supported
+func (c Context) Hijack(resp *Response, fn func(conn io.ReadWriteCloser) error) *Response {
+	if c.Request.Version == "HTTP/2.0" {
+		return NewTextResponse("connection can not be hijacked on HTTP/2").SetCode(505)
+	}
+
+	// the watcher of the request keeps the bytes that are read while it is watching
+	conn := c.Conn
+
+	resp.Stream = func(ctx context.Context, w io.Writer) error {
+		defer conn.Close()
+
+		stop := context.AfterFunc(ctx, func() { conn.Close() })
+		defer stop()
+
+		// the read deadline must not be reset by the watcher after fn sets it
+		if watcher, ok := conn.(*connWatcher); ok {
+			watcher.stop()
+		}
+
+		return fn(conn)
+	}
+
+	return resp
+}
@@ -0,0 +1,456 @@
+package chttp
+
+import (
+	"errors"
+	"sync"
+)
+
+var errHpack = errors.New("hpack: invalid header block")
+
+type hpackField struct {
+	name  string
+	value string
+}
+
+// size of the field in the table, see RFC 7541 Section 4.1
+func (f hpackField) size() uint32 {
+	return uint32(len(f.name) + len(f.value) + 32)
+}
+
+// dynamic table, the newest entry is the last entry of the slice
+type hpackTable struct {
+	entries []hpackField
+	size    uint32
+	maxSize uint32
+}
+
+func (t *hpackTable) add(f hpackField) {
+	t.entries = append(t.entries, f)
+	t.size += f.size()
+	t.evict()
+}
+
+func (t *hpackTable) setMaxSize(size uint32) {
+	t.maxSize = size
+	t.evict()
+}
+
+func (t *hpackTable) evict() {
+	n := 0
+	for t.size > t.maxSize && n < len(t.entries) {
+		t.size -= t.entries[n].size()
+		n++
+	}
+
+	if n > 0 {
+		t.entries = append(t.entries[:0], t.entries[n:]...)
+	}
+}
+
+// get the field by index, index 1 is the first static entry and index 62 is the newest dynamic entry
+func (t *hpackTable) get(index uint64) (hpackField, bool) {
+	if index == 0 {
+		return hpackField{}, false
+	}
+
+	if index <= uint64(len(hpackStaticTable)) {
+		return hpackStaticTable[index-1], true
+	}
+
+	i := index - uint64(len(hpackStaticTable)) - 1
+	if i >= uint64(len(t.entries)) {
+		return hpackField{}, false
+	}
+
+	return t.entries[len(t.entries)-1-int(i)], true
+}
+
+// find the index of the field, exact is true if the value is also matched
+func (t *hpackTable) search(f hpackField) (index uint64, exact bool) {
+	for i, entry := range hpackStaticTable {
+		if entry.name != f.name {
+			continue
+		}
+
+		if entry.value == f.value {
+			return uint64(i + 1), true
+		}
+
+		if index == 0 {
+			index = uint64(i + 1)
+		}
+	}
+
+	for i := len(t.entries) - 1; i >= 0; i-- {
+		entry := t.entries[i]
+		if entry.name != f.name {
+			continue
+		}
+
+		dynamicIndex := uint64(len(hpackStaticTable) + len(t.entries) - i)
+		if entry.value == f.value {
+			return dynamicIndex, true
+		}
+
+		if index == 0 {
+			index = dynamicIndex
+		}
+	}
+
+	return index, false
+}
+
+type hpackDecoder struct {
+	table hpackTable
+	// the table size that is allowed by our SETTINGS_HEADER_TABLE_SIZE
+	maxTableSize uint32
+}
+
+func newHpackDecoder(maxTableSize uint32) *hpackDecoder {
+	return &hpackDecoder{
+		table:        hpackTable{maxSize: maxTableSize},
+		maxTableSize: maxTableSize,
+	}
+}
+
+// decode a complete header block, the dynamic table is updated along the way
+func (d *hpackDecoder) decode(block []byte) ([]hpackField, error) {
+	var fields []hpackField
+
+	first := true
+	for len(block) > 0 {
+		b := block[0]
+
+		var err error
+		switch {
+		// indexed header field
+		case b&0x80 != 0:
+			var index uint64
+			index, block, err = hpackReadInt(block, 7)
+			if err != nil {
+				return nil, err
+			}
+
+			f, ok := d.table.get(index)
+			if !ok {
+				return nil, errHpack
+			}
+			fields = append(fields, f)
+
+		// literal with incremental indexing
+		case b&0xc0 == 0x40:
+			var f hpackField
+			f, block, err = d.readLiteral(block, 6)
+			if err != nil {
+				return nil, err
+			}
+
+			d.table.add(f)
+			fields = append(fields, f)
+
+		// dynamic table size update, only allowed at the beginning of the block
+		case b&0xe0 == 0x20:
+			if !first {
+				return nil, errHpack
+			}
+
+			var size uint64
+			size, block, err = hpackReadInt(block, 5)
+			if err != nil {
+				return nil, err
+			}
+
+			if size > uint64(d.maxTableSize) {
+				return nil, errHpack
+			}
+			d.table.setMaxSize(uint32(size))
+
+			continue
+
+		// literal without indexing or never indexed
+		default:
+			var f hpackField
+			f, block, err = d.readLiteral(block, 4)
+			if err != nil {
+				return nil, err
+			}
+
+			fields = append(fields, f)
+		}
+
+		first = false
+	}
+
+	return fields, nil
+}
+
+func (d *hpackDecoder) readLiteral(block []byte, prefix uint8) (f hpackField, rest []byte, err error) {
+	index, rest, err := hpackReadInt(block, prefix)
+	if err != nil {
+		return f, nil, err
+	}
+
+	if index > 0 {
+		indexed, ok := d.table.get(index)
+		if !ok {
+			return f, nil, errHpack
+		}
+		f.name = indexed.name
+	} else {
+		f.name, rest, err = hpackReadString(rest)
+		if err != nil {
+			return f, nil, err
+		}
+	}
+
+	f.value, rest, err = hpackReadString(rest)
+
+	return f, rest, err
+}
+
+type hpackEncoder struct {
+	table hpackTable
+	// the smallest table size since the last header block, it must be sent before the next block
+	minSize uint32
+	pending bool
+}
+
+func newHpackEncoder() *hpackEncoder {
+	return &hpackEncoder{
+		table: hpackTable{maxSize: 4096},
+	}
+}
+
+// update the table size from the peer SETTINGS_HEADER_TABLE_SIZE, it is capped at 4096
+func (e *hpackEncoder) setMaxSize(size uint32) {
+	size = min(size, 4096)
+	if size == e.table.maxSize {
+		return
+	}
+
+	if !e.pending || size < e.minSize {
+		e.minSize = size
+	}
+	e.pending = true
+
+	e.table.setMaxSize(size)
+}
+
+func (e *hpackEncoder) encode(dst []byte, fields []hpackField) []byte {
+	if e.pending {
+		if e.minSize < e.table.maxSize {
+			dst = hpackAppendInt(dst, 0x20, 5, uint64(e.minSize))
+		}
+		dst = hpackAppendInt(dst, 0x20, 5, uint64(e.table.maxSize))
+		e.pending = false
+	}
+
+	for _, f := range fields {
+		index, exact := e.table.search(f)
+		if exact {
+			dst = hpackAppendInt(dst, 0x80, 7, index)
+			continue
+		}
+
+		// never index credentials, so they cannot be probed by compression
+		if hpackSensitive(f.name) {
+			dst = hpackAppendInt(dst, 0x10, 4, index)
+		} else {
+			dst = hpackAppendInt(dst, 0x40, 6, index)
+			e.table.add(f)
+		}
+
+		if index == 0 {
+			dst = hpackAppendString(dst, f.name)
+		}
+		dst = hpackAppendString(dst, f.value)
+	}
+
+	return dst
+}
+
+func hpackSensitive(name string) bool {
+	switch name {
+	case "authorization", "proxy-authorization", "set-cookie":
+		return true
+	}
+
+	return false
+}
+
+// integer representation from RFC 7541 Section 5.1
+func hpackReadInt(b []byte, prefix uint8) (uint64, []byte, error) {
+	if len(b) == 0 {
+		return 0, nil, errHpack
+	}
+
+	mask := uint64(1)<<prefix - 1
+	value := uint64(b[0]) & mask
+	if value < mask {
+		return value, b[1:], nil
+	}
+
+	var shift uint
+	for i := 1; i < len(b); i++ {
+		value += uint64(b[i]&0x7f) << shift
+		if b[i]&0x80 == 0 {
+			return value, b[i+1:], nil
+		}
+
+		shift += 7
+		if shift > 28 {
+			return 0, nil, errHpack
+		}
+	}
+
+	return 0, nil, errHpack
+}
+
+func hpackAppendInt(dst []byte, flags byte, prefix uint8, value uint64) []byte {
+	mask := uint64(1)<<prefix - 1
+	if value < mask {
+		return append(dst, flags|byte(value))
+	}
+
+	dst = append(dst, flags|byte(mask))
+	value -= mask
+	for value >= 0x80 {
+		dst = append(dst, byte(value)|0x80)
+		value >>= 7
+	}
+
+	return append(dst, byte(value))
+}
+
+func hpackReadString(b []byte) (string, []byte, error) {
+	if len(b) == 0 {
+		return "", nil, errHpack
+	}
+
+	huffman := b[0]&0x80 != 0
+	length, rest, err := hpackReadInt(b, 7)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if length > uint64(len(rest)) {
+		return "", nil, errHpack
+	}
+
+	s := rest[:length]
+	rest = rest[length:]
+	if !huffman {
+		return string(s), rest, nil
+	}
+
+	decoded, err := huffmanDecode(s)
+
+	return decoded, rest, err
+}
+
+// use huffman coding only if it is shorter
+func hpackAppendString(dst []byte, s string) []byte {
+	length := huffmanEncodedLen(s)
+	if length < len(s) {
+		dst = hpackAppendInt(dst, 0x80, 7, uint64(length))
+		return huffmanEncode(dst, s)
+	}
+
+	dst = hpackAppendInt(dst, 0, 7, uint64(len(s)))
+
+	return append(dst, s...)
+}
+
+func huffmanEncodedLen(s string) int {
+	var bits int
+	for i := 0; i < len(s); i++ {
+		bits += int(huffmanCodeLen[s[i]])
+	}
+
+	return (bits + 7) / 8
+}
+
+func huffmanEncode(dst []byte, s string) []byte {
+	var acc uint64
+	var bits uint
+
+	for i := 0; i < len(s); i++ {
+		acc = acc<<huffmanCodeLen[s[i]] | uint64(huffmanCodes[s[i]])
+		bits += uint(huffmanCodeLen[s[i]])
+
+		for bits >= 8 {
+			bits -= 8
+			dst = append(dst, byte(acc>>bits))
+		}
+	}
+
+	// pad with the most significant bits of EOS
+	if bits > 0 {
+		dst = append(dst, byte(acc<<(8-bits))|byte(0xff>>bits))
+	}
+
+	return dst
+}
+
+type huffmanNode struct {
+	next [2]*huffmanNode
+	sym  byte
+	leaf bool
+}
+
+var huffmanTree = sync.OnceValue(func() *huffmanNode {
+	root := &huffmanNode{}
+
+	for sym, code := range huffmanCodes {
+		node := root
+		for i := int(huffmanCodeLen[sym]) - 1; i >= 0; i-- {
+			bit := code >> i & 1
+			if node.next[bit] == nil {
+				node.next[bit] = &huffmanNode{}
+			}
+			node = node.next[bit]
+		}
+
+		node.sym = byte(sym)
+		node.leaf = true
+	}
+
+	return root
+})
+
+func huffmanDecode(b []byte) (string, error) {
+	root := huffmanTree()
+
+	out := make([]byte, 0, len(b)*8/5)
+	node := root
+	// bits that is read since the last symbol, they must be EOS padding at the end
+	var pending, ones int
+
+	for _, c := range b {
+		for i := 7; i >= 0; i-- {
+			bit := c >> i & 1
+
+			node = node.next[bit]
+			if node == nil {
+				return "", errHpack
+			}
+
+			pending++
+			if bit == 1 {
+				ones++
+			}
+
+			if node.leaf {
+				out = append(out, node.sym)
+				node = root
+				pending, ones = 0, 0
+			}
+		}
+	}
+
+	if pending > 7 || ones != pending {
+		return "", errHpack
+	}
+
+	return string(out), nil
+}
@@ -0,0 +1,179 @@
+package chttp
+
+import (
+	"encoding/hex"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+type hpackExample struct {
+	block  string
+	fields []hpackField
+	// size of the dynamic table after the block is decoded
+	tableSize uint32
+}
+
+func decodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+// every example is decoded by the same decoder, so the dynamic table is shared like on a connection
+func testHpackExamples(t *testing.T, maxTableSize uint32, examples []hpackExample) {
+	t.Helper()
+
+	decoder := newHpackDecoder(maxTableSize)
+	for i, example := range examples {
+		fields, err := decoder.decode(decodeHex(t, example.block))
+		if err != nil {
+			t.Fatalf("block %d: %v", i+1, err)
+		}
+
+		if !reflect.DeepEqual(fields, example.fields) {
+			t.Fatalf("block %d: got %v, want %v", i+1, fields, example.fields)
+		}
+
+		if decoder.table.size != example.tableSize {
+			t.Fatalf("block %d: table size is %d, want %d", i+1, decoder.table.size, example.tableSize)
+		}
+	}
+}
+
+// RFC 7541 Appendix C.2
+func TestHpackDecodeLiteral(t *testing.T) {
+	tests := []struct {
+		name    string
+		example hpackExample
+	}{
+		{"with indexing", hpackExample{
+			"400a 6375 7374 6f6d 2d6b 6579 0d63 7573 746f 6d2d 6865 6164 6572",
+			[]hpackField{{"custom-key", "custom-header"}},
+			55,
+		}},
+		{"without indexing", hpackExample{
+			"040c 2f73 616d 706c 652f 7061 7468",
+			[]hpackField{{":path", "/sample/path"}},
+			0,
+		}},
+		{"never indexed", hpackExample{
+			"1008 7061 7373 776f 7264 0673 6563 7265 74",
+			[]hpackField{{"password", "secret"}},
+			0,
+		}},
+		{"indexed", hpackExample{
+			"82",
+			[]hpackField{{":method", "GET"}},
+			0,
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testHpackExamples(t, 4096, []hpackExample{tt.example})
+		})
+	}
+}
+
+var hpackRequestFields = [][]hpackField{
+	{{":method", "GET"}, {":scheme", "http"}, {":path", "/"}, {":authority", "www.example.com"}},
+	{{":method", "GET"}, {":scheme", "http"}, {":path", "/"}, {":authority", "www.example.com"}, {"cache-control", "no-cache"}},
+	{{":method", "GET"}, {":scheme", "https"}, {":path", "/index.html"}, {":authority", "www.example.com"}, {"custom-key", "custom-value"}},
+}
+
+var hpackResponseFields = [][]hpackField{
+	{{":status", "302"}, {"cache-control", "private"}, {"date", "Mon, 21 Oct 2013 20:13:21 GMT"}, {"location", "https://www.example.com"}},
+	{{":status", "307"}, {"cache-control", "private"}, {"date", "Mon, 21 Oct 2013 20:13:21 GMT"}, {"location", "https://www.example.com"}},
+	{
+		{":status", "200"}, {"cache-control", "private"}, {"date", "Mon, 21 Oct 2013 20:13:22 GMT"}, {"location", "https://www.example.com"},
+		{"content-encoding", "gzip"}, {"set-cookie", "foo=ASDJKHQKBZXOQWEOPIUAXQWEOIU; max-age=3600; version=1"},
+	},
+}
+
+// RFC 7541 Appendix C.3 and C.4
+func TestHpackDecodeRequests(t *testing.T) {
+	tests := []struct {
+		name   string
+		blocks []string
+	}{
+		{"without huffman", []string{
+			"8286 8441 0f77 7777 2e65 7861 6d70 6c65 2e63 6f6d",
+			"8286 84be 5808 6e6f 2d63 6163 6865",
+			"8287 85bf 400a 6375 7374 6f6d 2d6b 6579 0c63 7573 746f 6d2d 7661 6c75 65",
+		}},
+		{"with huffman", []string{
+			"8286 8441 8cf1 e3c2 e5f2 3a6b a0ab 90f4 ff",
+			"8286 84be 5886 a8eb 1064 9cbf",
+			"8287 85bf 4088 25a8 49e9 5ba9 7d7f 8925 a849 e95b b8e8 b4bf",
+		}},
+	}
+
+	sizes := []uint32{57, 110, 164}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var examples []hpackExample
+			for i, block := range tt.blocks {
+				examples = append(examples, hpackExample{block, hpackRequestFields[i], sizes[i]})
+			}
+
+			testHpackExamples(t, 4096, examples)
+		})
+	}
+}
+
+// RFC 7541 Appendix C.5 and C.6, the table is limited to 256 bytes so the entries are evicted
+func TestHpackDecodeResponses(t *testing.T) {
+	tests := []struct {
+		name   string
+		blocks []string
+	}{
+		{"without huffman", []string{
+			"4803 3330 3258 0770 7269 7661 7465 611d 4d6f 6e2c 2032 3120 4f63 7420 3230 3133 2032 303a 3133 3a32 3120 474d 546e 1768 7474 7073 3a2f 2f77 7777 2e65 7861 6d70 6c65 2e63 6f6d",
+			"4803 3330 37c1 c0bf",
+			"88c1 611d 4d6f 6e2c 2032 3120 4f63 7420 3230 3133 2032 303a 3133 3a32 3220 474d 54c0 5a04 677a 6970 7738 666f 6f3d 4153 444a 4b48 514b 425a 584f 5157 454f 5049 5541 5851 5745 4f49 553b 206d 6178 2d61 6765 3d33 3630 303b 2076 6572 7369 6f6e 3d31",
+		}},
+		{"with huffman", []string{
+			"4882 6402 5885 aec3 771a 4b61 96d0 7abe 9410 54d4 44a8 2005 9504 0b81 66e0 82a6 2d1b ff6e 919d 29ad 1718 63c7 8f0b 97c8 e9ae 82ae 43d3",
+			"4883 640e ffc1 c0bf",
+			"88c1 6196 d07a be94 1054 d444 a820 0595 040b 8166 e084 a62d 1bff c05a 839b d9ab 77ad 94e7 821d d7f2 e6c7 b335 dfdf cd5b 3960 d5af 2708 7f36 72c1 ab27 0fb5 291f 9587 3160 65c0 03ed 4ee5 b106 3d50 07",
+		}},
+	}
+
+	sizes := []uint32{222, 222, 215}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var examples []hpackExample
+			for i, block := range tt.blocks {
+				examples = append(examples, hpackExample{block, hpackResponseFields[i], sizes[i]})
+			}
+
+			testHpackExamples(t, 256, examples)
+		})
+	}
+}
+
+// the encoder state must match the decoder state across blocks
+func TestHpackRoundTrip(t *testing.T) {
+	encoder := newHpackEncoder()
+	decoder := newHpackDecoder(4096)
+
+	for i, want := range slices.Concat(hpackRequestFields, hpackResponseFields) {
+		got, err := decoder.decode(encoder.encode(nil, want))
+		if err != nil {
+			t.Fatalf("block %d: %v", i+1, err)
+		}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("block %d: got %v, want %v", i+1, got, want)
+		}
+	}
+}
@@ -2,26 +2,39 @@ package chttp
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
+	"fmt"
 	"io"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"strings"
+	"time"
 )
 
 type Context struct {
 	context.Context
 	Request *Request
 	Conn    io.ReadWriteCloser
+	// path of the matched route, it will be empty if no route is matched
+	Pattern string
 }
 
 type Handler func(c Context) *Response
 type ErrHandler func(c Context, err error) *Response
 
+// middleware wrap a handler, use [Router.Use] to register it for every route
+type Middleware func(next Handler) Handler
+
 type Route struct {
 	Handler map[string]Handler
-	path    string
+	// deadline for every request on this route, it will use [Router.Timeout] if zero
+	Timeout time.Duration
+	// the request body is not read into [Request.Body], the handler reads it from [Request.BodyReader]
+	StreamBody bool
+	path       string
 }
 
 func (r Route) methodIsExist(method string) bool {
@@ -40,6 +53,13 @@ type Router struct {
 	Handler      map[string]Route
 	NotFound     Handler
 	ErrorHandler ErrHandler
+	Middlewares  []Middleware
+	// default deadline for every request, zero means no deadline
+	Timeout time.Duration
+	// start a span for every request, nil means tracing is disabled
+	Tracer Tracer
+	// same as [Route.StreamBody] for the request that is handled by NotFound
+	NotFoundStreamBody bool
 }
 
 func NewRouter() *Router {
@@ -49,13 +69,32 @@ func NewRouter() *Router {
 			return NewTextResponse("404 Not Found").SetCode(404)
 		},
 		ErrorHandler: func(c Context, err error) *Response {
-			slog.Error("Error", "err", err)
+			if errors.Is(err, ErrRequestTooLarge) {
+				return NewTextResponse("413 Content Too Large").SetCode(413)
+			}
+
+			slog.ErrorContext(c, "Error", "err", err)
 
 			return NewTextResponse("500 Internal Server Error").SetCode(500)
 		},
 	}
 }
 
+// register middlewares for every route, the first middleware will be the outermost one
+func (r *Router) Use(middlewares ...Middleware) *Router {
+	r.Middlewares = append(r.Middlewares, middlewares...)
+
+	return r
+}
+
+func (r Router) wrap(handler Handler) Handler {
+	for i := len(r.Middlewares) - 1; i >= 0; i-- {
+		handler = r.Middlewares[i](handler)
+	}
+
+	return handler
+}
+
 func (r Router) routeIsExist(path string) bool {
 	_, ok := r.Handler[path]
 	return ok
@@ -91,70 +130,168 @@ func (r *Router) HandleFunc(path string, handler Handler) *Router {
 	return r
 }
 
+// same as [Router.HandleFunc], but every request on the route will be cancelled after the timeout
+func (r *Router) HandleFuncTimeout(path string, timeout time.Duration, handler Handler) *Router {
+	r.HandleFunc(path, handler)
+
+	_, path = parsePath(path)
+	route := r.Handler[path]
+	route.Timeout = timeout
+	r.Handler[path] = route
+
+	return r
+}
+
+// same as [Router.HandleFunc], but the request body is streamed to the handler, see [Route.StreamBody]
+func (r *Router) HandleFuncStream(path string, handler Handler) *Router {
+	r.HandleFunc(path, handler)
+
+	_, path = parsePath(path)
+	route := r.Handler[path]
+	route.StreamBody = true
+	r.Handler[path] = route
+
+	return r
+}
+
+// the request body of the route is read by the handler
+func (r *Router) streamBody(req *Request) bool {
+	if route, ok := r.Handler[req.Path]; ok {
+		return route.StreamBody
+	}
+
+	return r.NotFoundStreamBody
+}
+
+// execute a request from the connection, the request context will be derived from [context.Background]
 func (r *Router) Execute(conn io.ReadWriteCloser) error {
-	// parse request
-	req, err := NewRequest(conn)
+	return r.ExecuteContext(context.Background(), conn)
+}
+
+// execute a request from the connection, the request context will be derived from ctx.
+// it will be cancelled when the route deadline is elapsed or the client closed the connection
+func (r *Router) ExecuteContext(ctx context.Context, conn io.ReadWriteCloser) error {
+	// parse request, the body is read after the route is known
+	req, err := readRequestHead(conn)
+
+	stream := err == nil && r.streamBody(&req)
+	if err == nil && !stream {
+		err = readRequestBody(&req, conn)
+	}
+
+	c := Context{
+		Context: ctx,
+		Request: &req,
+		Conn:    conn,
+	}
+
 	if err != nil {
-		resp := r.ErrorHandler(Context{
-			Context: context.Background(),
-			Request: &req,
-			Conn:    conn,
-		}, err)
+		resp := r.ErrorHandler(c, err)
 
 		resp.Write(conn)
 
 		return err
 	}
 
-	// get route
-	route, ok := r.Handler[req.Path]
-	if !ok {
-		resp := r.NotFound(Context{
-			Context: context.Background(),
-			Request: &req,
-			Conn:    conn,
-		})
+	// keep the tls state if the connection is not served by [Server]
+	if tlsConn, ok := conn.(*tls.Conn); ok && c.TLS() == nil {
+		state := tlsConn.ConnectionState()
+		c.Context = context.WithValue(c.Context, tlsStateKey{}, &state)
+	}
 
-		resp.Write(conn)
+	// cancel the request context when client disconnected
+	var cancel context.CancelFunc
+	c.Context, cancel = context.WithCancel(c.Context)
+	defer cancel()
 
-		return nil
+	if netConn, ok := conn.(net.Conn); ok {
+		watcher := watchConn(netConn, cancel)
+		defer watcher.stop()
+
+		c.Conn = watcher
+	}
+
+	// the body is read through the watcher, so the read is not raced
+	if stream {
+		if err := streamRequestBody(&c); err != nil {
+			r.ErrorHandler(c, err).write(c, conn)
+
+			return err
+		}
+	}
+
+	resp, err := r.serve(c)
+
+	// stream is cancelled when the client disconnected, not when the handler deadline is elapsed
+	if err := resp.write(c, conn); err != nil && resp != nil && resp.Stream != nil {
+		slog.DebugContext(c, "Error while writing stream", "err", err)
+	}
+
+	return err
+}
+
+// find the route of the request and run it with the middlewares, a handler error or panic
+// will be passed to ErrorHandler. it is shared by HTTP/1.1 and HTTP/2 connections
+func (r *Router) serve(c Context) (resp *Response, err error) {
+	handler := r.NotFound
+	timeout := r.Timeout
+
+	route, ok := r.Handler[c.Request.Path]
+	if ok {
+		c.Pattern = route.path
+		handler = route.getHandler(c.Request.Method)
+
+		if route.Timeout > 0 {
+			timeout = route.Timeout
+		}
+	}
+
+	if timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		c.Context, cancelTimeout = context.WithTimeout(c.Context, timeout)
+		defer cancelTimeout()
 	}
 
-	// get handler
-	handler := route.getHandler(req.Method)
+	var span Span
+	if r.Tracer != nil {
+		c.Context, span = startRequestSpan(r.Tracer, c)
+	}
+
+	handler = r.wrap(handler)
 
-	var resp *Response
+	var headers map[string]string
 	func() {
 		defer func() {
 			rc := recover()
+
+			// use the request id and trace context of the middleware
+			if rp, ok := rc.(*requestPanic); ok {
+				c.Context = rp.ctx
+				headers = rp.headers
+				rc = rp.value
+			}
+
 			if rc != nil {
-				err = rc.(error)
+				err = panicError(rc)
 			}
 		}()
 
 		// execute handler
-		resp = handler(Context{
-			Context: context.Background(),
-			Request: &req,
-			Conn:    conn,
-		})
+		resp = handler(c)
 	}()
 
 	if err != nil {
-		resp = r.ErrorHandler(Context{
-			Context: context.Background(),
-			Request: &req,
-			Conn:    conn,
-		}, err)
-
-		resp.Write(conn)
-
-		return err
+		resp = r.ErrorHandler(c, err)
+		if resp != nil && headers != nil {
+			setHeaders(resp, headers)
+		}
 	}
 
-	resp.Write(conn)
+	if span != nil {
+		endRequestSpan(span, resp, err)
+	}
 
-	return nil
+	return resp, err
 }
 
 func (r *Router) ServeFile(path string, filePath string) error {
@@ -210,6 +347,14 @@ func (r *Router) ServeDir(prefixPath string, filePath string) error {
 	return nil
 }
 
+func panicError(rc any) error {
+	if err, ok := rc.(error); ok {
+		return err
+	}
+
+	return fmt.Errorf("%v", rc)
+}
+
 func parsePath(uri string) (method, path string) {
 	s := strings.Split(uri, " ")
 
@@ -0,0 +1,1237 @@
+package chttp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var errHTTP2StreamWrite = errors.New("http2: connection of the stream cannot be written directly")
+
+type HTTP2Option struct {
+	// max streams that the client can open at the same time
+	MaxConcurrentStreams uint32
+	// flow control window of every stream and the connection that is advertised to the client
+	InitialWindowSize uint32
+	// largest frame payload that is accepted from the client
+	MaxFrameSize uint32
+	// max size of the request header block
+	MaxHeaderListSize uint32
+	// larger request body will be answered with 413 Content Too Large
+	MaxRequestBodySize int
+	// max bytes of the request bodies that are held by a connection, including the bodies of the
+	// running handlers. the stream whose DATA goes over it is refused, it is at least MaxRequestBodySize
+	MaxBufferedBodySize int
+	// serve HTTP/2 on cleartext connection (h2c) with prior knowledge or "Upgrade: h2c"
+	H2C bool
+}
+
+var DefaultHTTP2Option = HTTP2Option{
+	MaxConcurrentStreams: 250,
+	InitialWindowSize:    1 << 20,
+	MaxFrameSize:         1 << 20,
+	MaxHeaderListSize:    1 << 20,
+	MaxRequestBodySize:   maxRequestBodySize,
+	MaxBufferedBodySize:  4 * maxRequestBodySize,
+}
+
+func (o HTTP2Option) normalize() HTTP2Option {
+	if o.MaxConcurrentStreams == 0 {
+		o.MaxConcurrentStreams = DefaultHTTP2Option.MaxConcurrentStreams
+	}
+
+	if o.InitialWindowSize == 0 {
+		o.InitialWindowSize = DefaultHTTP2Option.InitialWindowSize
+	}
+	o.InitialWindowSize = min(o.InitialWindowSize, http2MaxWindowSize)
+
+	if o.MaxFrameSize == 0 {
+		o.MaxFrameSize = DefaultHTTP2Option.MaxFrameSize
+	}
+	o.MaxFrameSize = min(max(o.MaxFrameSize, http2DefaultMaxFrameSize), http2MaxFrameSizeLimit)
+
+	if o.MaxHeaderListSize == 0 {
+		o.MaxHeaderListSize = DefaultHTTP2Option.MaxHeaderListSize
+	}
+
+	if o.MaxRequestBodySize <= 0 {
+		o.MaxRequestBodySize = DefaultHTTP2Option.MaxRequestBodySize
+	}
+
+	if o.MaxBufferedBodySize <= 0 {
+		o.MaxBufferedBodySize = DefaultHTTP2Option.MaxBufferedBodySize
+	}
+	o.MaxBufferedBodySize = max(o.MaxBufferedBodySize, o.MaxRequestBodySize)
+
+	return o
+}
+
+func (s *Server) http2Option() HTTP2Option {
+	if s.HTTP2 == nil {
+		return DefaultHTTP2Option.normalize()
+	}
+
+	return s.HTTP2.normalize()
+}
+
+// serve the connection with HTTP/2 if it is negotiated by ALPN or sent with h2c, otherwise HTTP/1.1
+func (s *Server) serveProtocol(ctx context.Context, conn net.Conn) error {
+	if s.DisableHTTP2 {
+		return s.Router.ExecuteContext(ctx, conn)
+	}
+
+	if state, ok := ctx.Value(tlsStateKey{}).(*tls.ConnectionState); ok {
+		if state.NegotiatedProtocol == "h2" {
+			return s.serveHTTP2(ctx, conn, nil, nil)
+		}
+
+		return s.Router.ExecuteContext(ctx, conn)
+	}
+
+	if s.http2Option().H2C {
+		return s.serveH2C(ctx, conn)
+	}
+
+	return s.Router.ExecuteContext(ctx, conn)
+}
+
+// detect the client preface (prior knowledge) or "Upgrade: h2c" on a cleartext connection
+func (s *Server) serveH2C(ctx context.Context, conn net.Conn) error {
+	buf := make([]byte, 1024)
+
+	n, err := conn.Read(buf)
+	if err != nil {
+		return err
+	}
+
+	// the preface may be split, keep reading while it still look like the preface
+	for n < len(http2Preface) && strings.HasPrefix(http2Preface, string(buf[:n])) {
+		m, err := conn.Read(buf[n:])
+		n += m
+		if err != nil {
+			break
+		}
+	}
+	buf = buf[:n]
+
+	if bytes.HasPrefix(buf, []byte(http2Preface)) {
+		return s.serveHTTP2(ctx, &bufferedConn{Conn: conn, buf: buf}, nil, nil)
+	}
+
+	req, err := NewRequestFromBuffer(buf)
+	if err == nil {
+		if settings, ok := h2cUpgrade(req); ok {
+			_, err := conn.Write([]byte("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: h2c\r\n\r\n"))
+			if err != nil {
+				return err
+			}
+
+			return s.serveHTTP2(ctx, conn, &req, settings)
+		}
+	}
+
+	return s.Router.ExecuteContext(ctx, &bufferedConn{Conn: conn, buf: buf})
+}
+
+// check the upgrade headers and decode HTTP2-Settings, request with a body is not upgraded
+func h2cUpgrade(req Request) ([]http2Setting, bool) {
+	if !headerContainsToken(req.GetHeader("Upgrade"), "h2c") ||
+		!headerContainsToken(req.GetHeader("Connection"), "upgrade") ||
+		!headerContainsToken(req.GetHeader("Connection"), "http2-settings") {
+		return nil, false
+	}
+
+	if req.Body != "" || (req.GetHeader("Content-Length") != "" && req.GetHeader("Content-Length") != "0") {
+		return nil, false
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(req.GetHeader("HTTP2-Settings"), "="))
+	if err != nil {
+		return nil, false
+	}
+
+	settings, err := parseHTTP2Settings(payload)
+	if err != nil {
+		return nil, false
+	}
+
+	return settings, true
+}
+
+func headerContainsToken(value, token string) bool {
+	for _, v := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(v), token) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *Server) serveHTTP2(ctx context.Context, conn net.Conn, upgrade *Request, settings []http2Setting) error {
+	sc := newHTTP2Conn(ctx, s.Router, conn, s.http2Option())
+
+	return sc.serve(upgrade, settings)
+}
+
+// a HTTP/2 connection, frames are read on the serve goroutine and
+// every stream is handled on its own goroutine
+type http2Conn struct {
+	router *Router
+	option HTTP2Option
+	conn   net.Conn
+	ctx    context.Context
+	cancel context.CancelFunc
+	br     *bufio.Reader
+
+	// guard the writer and the encoder
+	wmu     sync.Mutex
+	bw      *bufio.Writer
+	encoder *hpackEncoder
+
+	// only used by the serve goroutine
+	decoder     *hpackDecoder
+	recvWindow  int64
+	header      *http2Stream
+	headerBlock []byte
+	headerEnd   bool
+
+	mu               sync.Mutex
+	cond             *sync.Cond
+	streams          map[uint32]*http2Stream
+	lastStreamID     uint32
+	sendWindow       int64
+	peerWindowSize   int64
+	peerMaxFrameSize uint32
+	goingAway        bool
+	closed           bool
+	// streams that count toward MaxConcurrentStreams and the request body bytes that they hold,
+	// a reset stream is counted until its handler returns
+	active   uint32
+	buffered int
+
+	wg sync.WaitGroup
+}
+
+type http2Stream struct {
+	sc     *http2Conn
+	id     uint32
+	ctx    context.Context
+	cancel context.CancelFunc
+	req    *Request
+
+	// only used by the serve goroutine
+	body         []byte
+	recvWindow   int64
+	remoteClosed bool
+	refused      bool
+	trailer      bool
+
+	// guarded by http2Conn.mu
+	sendWindow int64
+	reset      bool
+	counted    bool
+	dispatched bool
+	buffered   int
+}
+
+func newHTTP2Conn(ctx context.Context, router *Router, conn net.Conn, option HTTP2Option) *http2Conn {
+	sc := &http2Conn{
+		router:           router,
+		option:           option,
+		conn:             conn,
+		br:               bufio.NewReader(conn),
+		bw:               bufio.NewWriter(conn),
+		encoder:          newHpackEncoder(),
+		decoder:          newHpackDecoder(4096),
+		recvWindow:       http2DefaultWindowSize,
+		streams:          make(map[uint32]*http2Stream),
+		sendWindow:       http2DefaultWindowSize,
+		peerWindowSize:   http2DefaultWindowSize,
+		peerMaxFrameSize: http2DefaultMaxFrameSize,
+	}
+	sc.cond = sync.NewCond(&sc.mu)
+	sc.ctx, sc.cancel = context.WithCancel(ctx)
+
+	return sc
+}
+
+func (sc *http2Conn) serve(upgrade *Request, settings []http2Setting) error {
+	defer sc.close()
+
+	// stop accepting new streams when the server is shutdown
+	go func() {
+		<-sc.ctx.Done()
+		sc.shutdown()
+	}()
+
+	err := sc.writeFrame(http2FrameSettings, 0, 0, appendHTTP2Settings(nil,
+		http2Setting{http2SettingMaxConcurrentStreams, sc.option.MaxConcurrentStreams},
+		http2Setting{http2SettingInitialWindowSize, sc.option.InitialWindowSize},
+		http2Setting{http2SettingMaxFrameSize, sc.option.MaxFrameSize},
+		http2Setting{http2SettingMaxHeaderListSize, sc.option.MaxHeaderListSize},
+	))
+	if err != nil {
+		return err
+	}
+
+	if delta := int64(sc.option.InitialWindowSize) - http2DefaultWindowSize; delta > 0 {
+		if err := sc.writeWindowUpdate(0, uint32(delta)); err != nil {
+			return err
+		}
+		sc.recvWindow += delta
+	}
+
+	// the upgrade request become stream 1 that is already half-closed
+	if upgrade != nil {
+		if err := sc.applySettings(settings); err != nil {
+			return err
+		}
+
+		st := sc.newStream(1)
+		st.req = upgrade
+		st.body = []byte(upgrade.Body)
+		upgrade.Version = "HTTP/2.0"
+
+		sc.mu.Lock()
+		sc.lastStreamID = 1
+		sc.addStream(st)
+		sc.mu.Unlock()
+
+		sc.dispatch(st)
+	}
+
+	preface := make([]byte, len(http2Preface))
+	if _, err := io.ReadFull(sc.br, preface); err != nil {
+		return err
+	}
+
+	if string(preface) != http2Preface {
+		sc.goAway(http2ErrProtocol)
+		return errors.New("http2: invalid client preface")
+	}
+
+	first := true
+	for {
+		frame, err := readHTTP2Frame(sc.br, sc.option.MaxFrameSize)
+		if err == nil && first && frame.Type != http2FrameSettings {
+			err = http2ConnError{http2ErrProtocol, "first frame is not SETTINGS"}
+		}
+		first = false
+
+		if err == nil {
+			err = sc.processFrame(frame)
+		}
+
+		var streamErr http2StreamError
+		if errors.As(err, &streamErr) {
+			sc.resetStream(streamErr.streamID, streamErr.code)
+			continue
+		}
+
+		var connErr http2ConnError
+		if errors.As(err, &connErr) {
+			sc.goAway(connErr.code)
+			return err
+		}
+
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+
+			return err
+		}
+	}
+}
+
+// cancel every stream and wait for the handlers
+func (sc *http2Conn) close() {
+	sc.mu.Lock()
+	sc.closed = true
+	for _, st := range sc.streams {
+		st.cancel()
+	}
+	sc.cond.Broadcast()
+	sc.mu.Unlock()
+
+	sc.cancel()
+	sc.conn.Close()
+	sc.wg.Wait()
+}
+
+// send GOAWAY and close the connection after the active streams are finished
+func (sc *http2Conn) shutdown() {
+	sc.mu.Lock()
+	if sc.closed || sc.goingAway {
+		sc.mu.Unlock()
+		return
+	}
+	sc.goingAway = true
+	idle := len(sc.streams) == 0
+	sc.mu.Unlock()
+
+	sc.goAway(http2ErrNo)
+
+	if idle {
+		sc.conn.Close()
+	}
+}
+
+func (sc *http2Conn) processFrame(frame http2Frame) error {
+	// header block must not be interleaved with other frames
+	if sc.header != nil && (frame.Type != http2FrameContinuation || frame.StreamID != sc.header.id) {
+		return http2ConnError{http2ErrProtocol, "expected CONTINUATION"}
+	}
+
+	switch frame.Type {
+	case http2FrameData:
+		return sc.processData(frame)
+	case http2FrameHeaders:
+		return sc.processHeaders(frame)
+	case http2FrameContinuation:
+		return sc.processContinuation(frame)
+	case http2FramePriority:
+		if frame.StreamID == 0 {
+			return http2ConnError{http2ErrProtocol, "PRIORITY on stream 0"}
+		}
+
+		if len(frame.Payload) != 5 {
+			return http2StreamError{frame.StreamID, http2ErrFrameSize}
+		}
+	case http2FrameRSTStream:
+		return sc.processRSTStream(frame)
+	case http2FrameSettings:
+		return sc.processSettings(frame)
+	case http2FramePushPromise:
+		return http2ConnError{http2ErrProtocol, "PUSH_PROMISE from client"}
+	case http2FramePing:
+		if frame.StreamID != 0 {
+			return http2ConnError{http2ErrProtocol, "PING on a stream"}
+		}
+
+		if len(frame.Payload) != 8 {
+			return http2ConnError{http2ErrFrameSize, "invalid PING length"}
+		}
+
+		if !frame.has(http2FlagAck) {
+			return sc.writeFrame(http2FramePing, http2FlagAck, 0, frame.Payload)
+		}
+	case http2FrameGoAway:
+		if frame.StreamID != 0 {
+			return http2ConnError{http2ErrProtocol, "GOAWAY on a stream"}
+		}
+
+		// finish the active streams, then close the connection
+		sc.mu.Lock()
+		sc.goingAway = true
+		idle := len(sc.streams) == 0
+		sc.mu.Unlock()
+
+		if idle {
+			return io.EOF
+		}
+	case http2FrameWindowUpdate:
+		return sc.processWindowUpdate(frame)
+	}
+
+	// unknown frame type must be ignored
+	return nil
+}
+
+func (sc *http2Conn) processHeaders(frame http2Frame) error {
+	id := frame.StreamID
+	if id == 0 {
+		return http2ConnError{http2ErrProtocol, "HEADERS on stream 0"}
+	}
+
+	payload, err := http2Unpad(frame)
+	if err != nil {
+		return err
+	}
+
+	if frame.has(http2FlagPriority) {
+		if len(payload) < 5 {
+			return http2ConnError{http2ErrProtocol, "invalid priority"}
+		}
+		payload = payload[5:]
+	}
+
+	sc.mu.Lock()
+	st := sc.streams[id]
+	lastStreamID := sc.lastStreamID
+	goingAway := sc.goingAway
+	active := sc.active
+	sc.mu.Unlock()
+
+	switch {
+	// trailers, they are read but not exposed to the handler
+	case st != nil:
+		if st.remoteClosed {
+			return http2ConnError{http2ErrStreamClosed, "HEADERS on half-closed stream"}
+		}
+
+		if !frame.has(http2FlagEndStream) {
+			return http2ConnError{http2ErrProtocol, "trailers without END_STREAM"}
+		}
+		st.trailer = true
+	case id%2 == 0:
+		return http2ConnError{http2ErrProtocol, "stream id must be odd"}
+	case id <= lastStreamID:
+		return http2ConnError{http2ErrStreamClosed, "HEADERS on closed stream"}
+	default:
+		st = sc.newStream(id)
+		st.refused = goingAway || active >= sc.option.MaxConcurrentStreams
+
+		sc.mu.Lock()
+		sc.lastStreamID = id
+		sc.mu.Unlock()
+	}
+
+	sc.header = st
+	sc.headerEnd = frame.has(http2FlagEndStream)
+	sc.headerBlock = append(sc.headerBlock[:0], payload...)
+
+	if frame.has(http2FlagEndHeaders) {
+		return sc.endHeaders()
+	}
+
+	return nil
+}
+
+func (sc *http2Conn) processContinuation(frame http2Frame) error {
+	if sc.header == nil {
+		return http2ConnError{http2ErrProtocol, "unexpected CONTINUATION"}
+	}
+
+	sc.headerBlock = append(sc.headerBlock, frame.Payload...)
+	if len(sc.headerBlock) > int(sc.option.MaxHeaderListSize) {
+		return http2ConnError{http2ErrEnhanceYourCalm, "header block is too large"}
+	}
+
+	if frame.has(http2FlagEndHeaders) {
+		return sc.endHeaders()
+	}
+
+	return nil
+}
+
+// decode the complete header block, the stream is dispatched if the request has no body
+func (sc *http2Conn) endHeaders() error {
+	st := sc.header
+	endStream := sc.headerEnd
+	sc.header = nil
+
+	// the block must be decoded even if the stream is refused to keep the table in sync
+	fields, err := sc.decoder.decode(sc.headerBlock)
+	if err != nil {
+		return http2ConnError{http2ErrCompression, err.Error()}
+	}
+
+	if st.trailer {
+		sc.dispatch(st)
+		return nil
+	}
+
+	if st.refused {
+		return http2StreamError{st.id, http2ErrRefusedStream}
+	}
+
+	var size uint32
+	for _, f := range fields {
+		size += f.size()
+	}
+
+	if size > sc.option.MaxHeaderListSize {
+		return sc.rejectStream(st, 431, endStream)
+	}
+
+	st.req, err = newHTTP2Request(fields)
+	if err != nil {
+		return http2StreamError{st.id, http2ErrProtocol}
+	}
+
+	if length, err := strconv.Atoi(st.req.GetHeader("Content-Length")); err == nil && length > sc.option.MaxRequestBodySize {
+		return sc.rejectStream(st, 413, endStream)
+	}
+
+	sc.mu.Lock()
+	sc.addStream(st)
+	sc.mu.Unlock()
+
+	if endStream {
+		sc.dispatch(st)
+	}
+
+	return nil
+}
+
+func (sc *http2Conn) processData(frame http2Frame) error {
+	id := frame.StreamID
+	if id == 0 {
+		return http2ConnError{http2ErrProtocol, "DATA on stream 0"}
+	}
+
+	// the whole frame including padding is counted by flow control
+	length := int64(len(frame.Payload))
+	sc.recvWindow -= length
+	if sc.recvWindow < 0 {
+		return http2ConnError{http2ErrFlowControl, "connection window exceeded"}
+	}
+
+	if length > 0 {
+		if err := sc.writeWindowUpdate(0, uint32(length)); err != nil {
+			return err
+		}
+		sc.recvWindow += length
+	}
+
+	sc.mu.Lock()
+	st := sc.streams[id]
+	lastStreamID := sc.lastStreamID
+	sc.mu.Unlock()
+
+	if st == nil || st.remoteClosed {
+		if id > lastStreamID {
+			return http2ConnError{http2ErrProtocol, "DATA on idle stream"}
+		}
+
+		return http2StreamError{id, http2ErrStreamClosed}
+	}
+
+	st.recvWindow -= length
+	if st.recvWindow < 0 {
+		return http2StreamError{id, http2ErrFlowControl}
+	}
+
+	data, err := http2Unpad(frame)
+	if err != nil {
+		return err
+	}
+
+	if len(st.body)+len(data) > sc.option.MaxRequestBodySize {
+		return sc.rejectStream(st, 413, frame.has(http2FlagEndStream))
+	}
+
+	// the window is given back right away, so the memory of the connection is limited here
+	sc.mu.Lock()
+	full := sc.buffered+len(data) > sc.option.MaxBufferedBodySize
+	if !full {
+		sc.buffered += len(data)
+		st.buffered += len(data)
+	}
+	sc.mu.Unlock()
+
+	if full {
+		return http2StreamError{id, http2ErrRefusedStream}
+	}
+	st.body = append(st.body, data...)
+
+	if frame.has(http2FlagEndStream) {
+		sc.dispatch(st)
+		return nil
+	}
+
+	if length > 0 {
+		if err := sc.writeWindowUpdate(id, uint32(length)); err != nil {
+			return err
+		}
+		st.recvWindow += length
+	}
+
+	return nil
+}
+
+func (sc *http2Conn) processRSTStream(frame http2Frame) error {
+	if frame.StreamID == 0 {
+		return http2ConnError{http2ErrProtocol, "RST_STREAM on stream 0"}
+	}
+
+	if len(frame.Payload) != 4 {
+		return http2ConnError{http2ErrFrameSize, "invalid RST_STREAM length"}
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if frame.StreamID > sc.lastStreamID {
+		return http2ConnError{http2ErrProtocol, "RST_STREAM on idle stream"}
+	}
+
+	if st, ok := sc.streams[frame.StreamID]; ok {
+		sc.removeStream(st)
+	}
+
+	return nil
+}
+
+func (sc *http2Conn) processSettings(frame http2Frame) error {
+	if frame.StreamID != 0 {
+		return http2ConnError{http2ErrProtocol, "SETTINGS on a stream"}
+	}
+
+	if frame.has(http2FlagAck) {
+		if len(frame.Payload) != 0 {
+			return http2ConnError{http2ErrFrameSize, "SETTINGS ACK with payload"}
+		}
+
+		return nil
+	}
+
+	settings, err := parseHTTP2Settings(frame.Payload)
+	if err != nil {
+		return err
+	}
+
+	if err := sc.applySettings(settings); err != nil {
+		return err
+	}
+
+	return sc.writeFrame(http2FrameSettings, http2FlagAck, 0, nil)
+}
+
+func (sc *http2Conn) applySettings(settings []http2Setting) error {
+	for _, setting := range settings {
+		switch setting.ID {
+		case http2SettingHeaderTableSize:
+			sc.wmu.Lock()
+			sc.encoder.setMaxSize(setting.Value)
+			sc.wmu.Unlock()
+		case http2SettingInitialWindowSize:
+			sc.mu.Lock()
+			// the change is applied to the window of every active stream
+			delta := int64(setting.Value) - sc.peerWindowSize
+			sc.peerWindowSize = int64(setting.Value)
+			for _, st := range sc.streams {
+				st.sendWindow += delta
+				if st.sendWindow > http2MaxWindowSize {
+					sc.mu.Unlock()
+					return http2ConnError{http2ErrFlowControl, "stream window overflow"}
+				}
+			}
+			sc.cond.Broadcast()
+			sc.mu.Unlock()
+		case http2SettingMaxFrameSize:
+			sc.mu.Lock()
+			sc.peerMaxFrameSize = setting.Value
+			sc.mu.Unlock()
+		}
+	}
+
+	return nil
+}
+
+func (sc *http2Conn) processWindowUpdate(frame http2Frame) error {
+	if len(frame.Payload) != 4 {
+		return http2ConnError{http2ErrFrameSize, "invalid WINDOW_UPDATE length"}
+	}
+
+	increment := int64(binary.BigEndian.Uint32(frame.Payload) & 0x7fffffff)
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if frame.StreamID == 0 {
+		if increment == 0 {
+			return http2ConnError{http2ErrProtocol, "zero window increment"}
+		}
+
+		sc.sendWindow += increment
+		if sc.sendWindow > http2MaxWindowSize {
+			return http2ConnError{http2ErrFlowControl, "connection window overflow"}
+		}
+
+		sc.cond.Broadcast()
+
+		return nil
+	}
+
+	if frame.StreamID > sc.lastStreamID {
+		return http2ConnError{http2ErrProtocol, "WINDOW_UPDATE on idle stream"}
+	}
+
+	if increment == 0 {
+		return http2StreamError{frame.StreamID, http2ErrProtocol}
+	}
+
+	// the stream may be closed already
+	st, ok := sc.streams[frame.StreamID]
+	if !ok {
+		return nil
+	}
+
+	st.sendWindow += increment
+	if st.sendWindow > http2MaxWindowSize {
+		return http2StreamError{frame.StreamID, http2ErrFlowControl}
+	}
+
+	sc.cond.Broadcast()
+
+	return nil
+}
+
+func (sc *http2Conn) newStream(id uint32) *http2Stream {
+	st := &http2Stream{
+		sc:         sc,
+		id:         id,
+		recvWindow: int64(max(sc.option.InitialWindowSize, http2DefaultWindowSize)),
+	}
+	st.ctx, st.cancel = context.WithCancel(sc.ctx)
+
+	sc.mu.Lock()
+	st.sendWindow = sc.peerWindowSize
+	sc.mu.Unlock()
+
+	return st
+}
+
+// run the handler on its own goroutine after the request is completely received
+func (sc *http2Conn) dispatch(st *http2Stream) {
+	st.remoteClosed = true
+	st.req.Body = string(st.body)
+	st.body = nil
+
+	sc.mu.Lock()
+	st.dispatched = true
+	sc.mu.Unlock()
+
+	sc.wg.Add(1)
+	go func() {
+		defer sc.wg.Done()
+
+		c := Context{
+			Context: st.ctx,
+			Request: st.req,
+			Conn:    st,
+		}
+
+		resp, err := sc.router.serve(c)
+		if err != nil {
+			slog.Debug("Error while serving stream", "err", err)
+		}
+
+		if err := sc.writeResponse(st, resp); err != nil {
+			slog.Debug("Error while writing stream", "err", err)
+		}
+
+		sc.mu.Lock()
+		sc.removeStream(st)
+		sc.releaseStream(st)
+		sc.mu.Unlock()
+	}()
+}
+
+// answer the stream without running the handler, e.g. the request is too large
+func (sc *http2Conn) rejectStream(st *http2Stream, code int, endStream bool) error {
+	err := sc.writeHeaders(st, []hpackField{
+		{":status", strconv.Itoa(code)},
+		{"content-length", "0"},
+	}, true)
+
+	// the connection can be closed when the last stream is removed
+	sc.mu.Lock()
+	sc.removeStream(st)
+	sc.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	// tell the client to stop sending the body
+	if !endStream {
+		return sc.writeFrame(http2FrameRSTStream, 0, st.id, binary.BigEndian.AppendUint32(nil, uint32(http2ErrNo)))
+	}
+
+	return nil
+}
+
+func (sc *http2Conn) resetStream(id uint32, code http2ErrCode) {
+	sc.mu.Lock()
+	if st, ok := sc.streams[id]; ok {
+		sc.removeStream(st)
+	}
+	sc.mu.Unlock()
+
+	sc.writeFrame(http2FrameRSTStream, 0, id, binary.BigEndian.AppendUint32(nil, uint32(code)))
+}
+
+// caller must hold the lock
+func (sc *http2Conn) addStream(st *http2Stream) {
+	sc.streams[st.id] = st
+	st.counted = true
+	sc.active++
+}
+
+// caller must hold the lock. the stream of a running handler is still counted as active,
+// so the client can not open more streams by resetting them
+func (sc *http2Conn) removeStream(st *http2Stream) {
+	st.reset = true
+	st.cancel()
+	delete(sc.streams, st.id)
+	sc.cond.Broadcast()
+
+	if !st.dispatched {
+		sc.releaseStream(st)
+	}
+
+	if sc.goingAway && len(sc.streams) == 0 {
+		sc.conn.Close()
+	}
+}
+
+// the stream does not count toward the limits anymore, caller must hold the lock
+func (sc *http2Conn) releaseStream(st *http2Stream) {
+	if !st.counted {
+		return
+	}
+
+	st.counted = false
+	sc.active--
+	sc.buffered -= st.buffered
+	st.buffered = 0
+}
+
+func (sc *http2Conn) goAway(code http2ErrCode) {
+	sc.mu.Lock()
+	lastStreamID := sc.lastStreamID
+	sc.mu.Unlock()
+
+	payload := binary.BigEndian.AppendUint32(nil, lastStreamID)
+	payload = binary.BigEndian.AppendUint32(payload, uint32(code))
+
+	sc.writeFrame(http2FrameGoAway, 0, 0, payload)
+}
+
+func (sc *http2Conn) writeFrame(typ http2FrameType, flags uint8, streamID uint32, payload []byte) error {
+	sc.wmu.Lock()
+	defer sc.wmu.Unlock()
+
+	if err := writeHTTP2Frame(sc.bw, typ, flags, streamID, payload); err != nil {
+		return err
+	}
+
+	return sc.bw.Flush()
+}
+
+func (sc *http2Conn) writeWindowUpdate(streamID, increment uint32) error {
+	return sc.writeFrame(http2FrameWindowUpdate, 0, streamID, binary.BigEndian.AppendUint32(nil, increment))
+}
+
+// write a frame of the stream, nothing is written after the stream is reset
+func (sc *http2Conn) writeStreamFrame(st *http2Stream, typ http2FrameType, flags uint8, payload []byte) error {
+	sc.wmu.Lock()
+	defer sc.wmu.Unlock()
+
+	if sc.streamReset(st) {
+		return context.Canceled
+	}
+
+	if err := writeHTTP2Frame(sc.bw, typ, flags, st.id, payload); err != nil {
+		return err
+	}
+
+	return sc.bw.Flush()
+}
+
+// the write lock is held while it is checked, so no frame is sent after RST_STREAM
+func (sc *http2Conn) streamReset(st *http2Stream) bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	return st.reset || sc.closed
+}
+
+// encode the header block and split it into HEADERS and CONTINUATION frames,
+// nothing is written if the stream is already reset
+func (sc *http2Conn) writeHeaders(st *http2Stream, fields []hpackField, endStream bool) error {
+	sc.wmu.Lock()
+	defer sc.wmu.Unlock()
+
+	if sc.streamReset(st) {
+		return context.Canceled
+	}
+
+	sc.mu.Lock()
+	maxFrameSize := int(sc.peerMaxFrameSize)
+	sc.mu.Unlock()
+
+	block := sc.encoder.encode(nil, fields)
+
+	typ := http2FrameHeaders
+	var flags uint8
+	if endStream {
+		flags = http2FlagEndStream
+	}
+
+	for {
+		chunk := block[:min(len(block), maxFrameSize)]
+		block = block[len(chunk):]
+
+		if len(block) == 0 {
+			flags |= http2FlagEndHeaders
+		}
+
+		if err := writeHTTP2Frame(sc.bw, typ, flags, st.id, chunk); err != nil {
+			return err
+		}
+
+		if len(block) == 0 {
+			return sc.bw.Flush()
+		}
+
+		typ = http2FrameContinuation
+		flags = 0
+	}
+}
+
+// write DATA frames as the stream and connection window allow
+func (sc *http2Conn) writeData(st *http2Stream, data []byte, endStream bool) error {
+	for {
+		sc.mu.Lock()
+		var n int64
+		for {
+			if st.reset || sc.closed {
+				sc.mu.Unlock()
+				return context.Canceled
+			}
+
+			n = min(int64(len(data)), st.sendWindow, sc.sendWindow, int64(sc.peerMaxFrameSize))
+			if n > 0 || len(data) == 0 {
+				break
+			}
+
+			sc.cond.Wait()
+		}
+		st.sendWindow -= n
+		sc.sendWindow -= n
+		sc.mu.Unlock()
+
+		chunk := data[:n]
+		data = data[n:]
+
+		var flags uint8
+		if len(data) == 0 && endStream {
+			flags = http2FlagEndStream
+		}
+
+		if err := sc.writeStreamFrame(st, http2FrameData, flags, chunk); err != nil {
+			return err
+		}
+
+		if len(data) == 0 {
+			return nil
+		}
+	}
+}
+
+func (sc *http2Conn) writeResponse(st *http2Stream, resp *Response) error {
+	if resp == nil {
+		resp = NewResponse()
+	}
+
+	code := resp.Code
+	if code == 0 {
+		code = 200
+	}
+
+	contentType, ok := resp.Headers["Content-Type"]
+	if !ok {
+		contentType = "text/plain"
+	}
+
+	fields := []hpackField{
+		{":status", strconv.Itoa(code)},
+		{"content-type", contentType},
+	}
+
+	// the length of a stream is only sent if it is set by the handler
+	if resp.Stream == nil {
+		fields = append(fields, hpackField{"content-length", strconv.Itoa(len(resp.Body))})
+	} else if length, ok := resp.Headers["Content-Length"]; ok {
+		fields = append(fields, hpackField{"content-length", length})
+	}
+
+	for key, value := range resp.Headers {
+		name := strings.ToLower(key)
+		if name == "content-type" || name == "content-length" || http2ConnectionHeader(name) {
+			continue
+		}
+
+		fields = append(fields, hpackField{name, value})
+	}
+
+	for _, cookie := range resp.Cookies {
+		fields = append(fields, hpackField{"set-cookie", cookie.String()})
+	}
+
+	body := resp.Body
+	stream := resp.Stream
+	if st.req.Method == "HEAD" {
+		body = ""
+		stream = nil
+	}
+
+	if err := sc.writeHeaders(st, fields, body == "" && stream == nil); err != nil {
+		return err
+	}
+
+	if stream != nil {
+		err := stream(st.ctx, http2StreamWriter{st})
+		if werr := sc.writeData(st, nil, true); err == nil {
+			err = werr
+		}
+
+		return err
+	}
+
+	if body == "" {
+		return nil
+	}
+
+	return sc.writeData(st, []byte(body), true)
+}
+
+// every Write is sent as DATA frames of the stream, see [Response.Stream]
+type http2StreamWriter struct {
+	st *http2Stream
+}
+
+func (w http2StreamWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if err := w.st.sc.writeData(w.st, p, false); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// build the request from the header block, see RFC 9113 Section 8.3
+func newHTTP2Request(fields []hpackField) (*Request, error) {
+	req := &Request{
+		Version: "HTTP/2.0",
+		Headers: make(map[string]string),
+	}
+
+	var path, scheme, authority string
+	var cookies []string
+	regular := false
+
+	for _, f := range fields {
+		if strings.HasPrefix(f.name, ":") {
+			if regular {
+				return nil, errors.New("pseudo header after regular header")
+			}
+
+			var target *string
+			switch f.name {
+			case ":method":
+				target = &req.Method
+			case ":path":
+				target = &path
+			case ":scheme":
+				target = &scheme
+			case ":authority":
+				target = &authority
+			default:
+				return nil, errors.New("unknown pseudo header " + f.name)
+			}
+
+			if *target != "" {
+				return nil, errors.New("duplicated pseudo header " + f.name)
+			}
+			*target = f.value
+
+			continue
+		}
+		regular = true
+
+		if f.name != strings.ToLower(f.name) {
+			return nil, errors.New("uppercase header name")
+		}
+
+		if http2ConnectionHeader(f.name) || (f.name == "te" && f.value != "trailers") {
+			return nil, errors.New("connection specific header " + f.name)
+		}
+
+		// cookie may be split into multiple fields
+		if f.name == "cookie" {
+			cookies = append(cookies, f.value)
+			continue
+		}
+
+		key := textproto.CanonicalMIMEHeaderKey(f.name)
+		if existing, ok := req.Headers[key]; ok {
+			req.Headers[key] = existing + ", " + f.value
+		} else {
+			req.Headers[key] = f.value
+		}
+	}
+
+	if req.Method == "" || (req.Method != "CONNECT" && (path == "" || scheme == "")) {
+		return nil, errors.New("missing pseudo header")
+	}
+
+	if authority != "" {
+		req.Headers["Host"] = authority
+	}
+
+	if len(cookies) > 0 {
+		req.Headers["Cookie"] = strings.Join(cookies, "; ")
+		req.Cookie = parseCookie(req.Headers["Cookie"])
+	}
+
+	req.Method = strings.ToUpper(req.Method)
+	req.Path, req.Args = parseArgs(path)
+
+	var err error
+	req.URL, err = url.ParseRequestURI(path)
+	if err != nil {
+		req.URL = &url.URL{Path: req.Path}
+	}
+
+	return req, nil
+}
+
+func http2ConnectionHeader(name string) bool {
+	switch name {
+	case "connection", "keep-alive", "proxy-connection", "transfer-encoding", "upgrade":
+		return true
+	}
+
+	return false
+}
+
+// the request body is already in [Request.Body], so the stream cannot be read
+func (st *http2Stream) Read(p []byte) (int, error) {
+	return 0, io.EOF
+}
+
+// the response must be returned from the handler, writing the stream directly is not supported
+func (st *http2Stream) Write(p []byte) (int, error) {
+	return 0, errHTTP2StreamWrite
+}
+
+// reset the stream with CANCEL
+func (st *http2Stream) Close() error {
+	st.sc.resetStream(st.id, http2ErrCancel)
+	return nil
+}
+
+func (st *http2Stream) RemoteAddr() net.Addr {
+	return st.sc.conn.RemoteAddr()
+}
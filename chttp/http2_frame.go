@@ -0,0 +1,209 @@
+package chttp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// client connection preface, it is followed by a SETTINGS frame
+const http2Preface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+type http2FrameType uint8
+
+const (
+	http2FrameData         http2FrameType = 0x0
+	http2FrameHeaders      http2FrameType = 0x1
+	http2FramePriority     http2FrameType = 0x2
+	http2FrameRSTStream    http2FrameType = 0x3
+	http2FrameSettings     http2FrameType = 0x4
+	http2FramePushPromise  http2FrameType = 0x5
+	http2FramePing         http2FrameType = 0x6
+	http2FrameGoAway       http2FrameType = 0x7
+	http2FrameWindowUpdate http2FrameType = 0x8
+	http2FrameContinuation http2FrameType = 0x9
+)
+
+const (
+	http2FlagEndStream  uint8 = 0x1
+	http2FlagAck        uint8 = 0x1
+	http2FlagEndHeaders uint8 = 0x4
+	http2FlagPadded     uint8 = 0x8
+	http2FlagPriority   uint8 = 0x20
+)
+
+type http2ErrCode uint32
+
+const (
+	http2ErrNo                 http2ErrCode = 0x0
+	http2ErrProtocol           http2ErrCode = 0x1
+	http2ErrInternal           http2ErrCode = 0x2
+	http2ErrFlowControl        http2ErrCode = 0x3
+	http2ErrSettingsTimeout    http2ErrCode = 0x4
+	http2ErrStreamClosed       http2ErrCode = 0x5
+	http2ErrFrameSize          http2ErrCode = 0x6
+	http2ErrRefusedStream      http2ErrCode = 0x7
+	http2ErrCancel             http2ErrCode = 0x8
+	http2ErrCompression        http2ErrCode = 0x9
+	http2ErrConnect            http2ErrCode = 0xa
+	http2ErrEnhanceYourCalm    http2ErrCode = 0xb
+	http2ErrInadequateSecurity http2ErrCode = 0xc
+	http2ErrHTTP11Required     http2ErrCode = 0xd
+)
+
+type http2SettingID uint16
+
+const (
+	http2SettingHeaderTableSize      http2SettingID = 0x1
+	http2SettingEnablePush           http2SettingID = 0x2
+	http2SettingMaxConcurrentStreams http2SettingID = 0x3
+	http2SettingInitialWindowSize    http2SettingID = 0x4
+	http2SettingMaxFrameSize         http2SettingID = 0x5
+	http2SettingMaxHeaderListSize    http2SettingID = 0x6
+)
+
+const (
+	http2DefaultWindowSize   = 65535
+	http2DefaultMaxFrameSize = 16384
+	http2MaxFrameSizeLimit   = 1<<24 - 1
+	http2MaxWindowSize       = 1<<31 - 1
+)
+
+// error that close the whole connection with GOAWAY
+type http2ConnError struct {
+	code   http2ErrCode
+	reason string
+}
+
+func (e http2ConnError) Error() string {
+	return fmt.Sprintf("http2: connection error %d: %s", e.code, e.reason)
+}
+
+// error that only reset the stream with RST_STREAM
+type http2StreamError struct {
+	streamID uint32
+	code     http2ErrCode
+}
+
+func (e http2StreamError) Error() string {
+	return fmt.Sprintf("http2: stream %d error %d", e.streamID, e.code)
+}
+
+type http2Frame struct {
+	Type     http2FrameType
+	Flags    uint8
+	StreamID uint32
+	Payload  []byte
+}
+
+func (f http2Frame) has(flag uint8) bool {
+	return f.Flags&flag != 0
+}
+
+// read a frame, the payload is larger than maxSize is a FRAME_SIZE_ERROR
+func readHTTP2Frame(r io.Reader, maxSize uint32) (http2Frame, error) {
+	var header [9]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return http2Frame{}, err
+	}
+
+	length := uint32(header[0])<<16 | uint32(header[1])<<8 | uint32(header[2])
+	frame := http2Frame{
+		Type:     http2FrameType(header[3]),
+		Flags:    header[4],
+		StreamID: binary.BigEndian.Uint32(header[5:]) & 0x7fffffff,
+	}
+
+	if length > maxSize {
+		return frame, http2ConnError{http2ErrFrameSize, "frame is too large"}
+	}
+
+	frame.Payload = make([]byte, length)
+	if _, err := io.ReadFull(r, frame.Payload); err != nil {
+		return frame, err
+	}
+
+	return frame, nil
+}
+
+func writeHTTP2Frame(w io.Writer, typ http2FrameType, flags uint8, streamID uint32, payload []byte) error {
+	header := [9]byte{
+		byte(len(payload) >> 16), byte(len(payload) >> 8), byte(len(payload)),
+		byte(typ), flags,
+	}
+	binary.BigEndian.PutUint32(header[5:], streamID&0x7fffffff)
+
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+
+	_, err := w.Write(payload)
+
+	return err
+}
+
+// remove the padding of DATA and HEADERS frame
+func http2Unpad(frame http2Frame) ([]byte, error) {
+	payload := frame.Payload
+	if !frame.has(http2FlagPadded) {
+		return payload, nil
+	}
+
+	if len(payload) == 0 {
+		return nil, http2ConnError{http2ErrProtocol, "missing pad length"}
+	}
+
+	pad := int(payload[0])
+	if pad >= len(payload) {
+		return nil, http2ConnError{http2ErrProtocol, "pad length is too large"}
+	}
+
+	return payload[1 : len(payload)-pad], nil
+}
+
+type http2Setting struct {
+	ID    http2SettingID
+	Value uint32
+}
+
+func parseHTTP2Settings(payload []byte) ([]http2Setting, error) {
+	if len(payload)%6 != 0 {
+		return nil, http2ConnError{http2ErrFrameSize, "invalid settings length"}
+	}
+
+	settings := make([]http2Setting, 0, len(payload)/6)
+	for i := 0; i < len(payload); i += 6 {
+		setting := http2Setting{
+			ID:    http2SettingID(binary.BigEndian.Uint16(payload[i:])),
+			Value: binary.BigEndian.Uint32(payload[i+2:]),
+		}
+
+		switch setting.ID {
+		case http2SettingEnablePush:
+			if setting.Value > 1 {
+				return nil, http2ConnError{http2ErrProtocol, "invalid enable push"}
+			}
+		case http2SettingInitialWindowSize:
+			if setting.Value > http2MaxWindowSize {
+				return nil, http2ConnError{http2ErrFlowControl, "initial window size is too large"}
+			}
+		case http2SettingMaxFrameSize:
+			if setting.Value < http2DefaultMaxFrameSize || setting.Value > http2MaxFrameSizeLimit {
+				return nil, http2ConnError{http2ErrProtocol, "invalid max frame size"}
+			}
+		}
+
+		settings = append(settings, setting)
+	}
+
+	return settings, nil
+}
+
+func appendHTTP2Settings(dst []byte, settings ...http2Setting) []byte {
+	for _, setting := range settings {
+		dst = binary.BigEndian.AppendUint16(dst, uint16(setting.ID))
+		dst = binary.BigEndian.AppendUint32(dst, setting.Value)
+	}
+
+	return dst
+}
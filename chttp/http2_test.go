@@ -0,0 +1,342 @@
+package chttp
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// listener that accepts the connections that are sent to the channel
+type pipeListener struct {
+	conns  chan net.Conn
+	closed chan struct{}
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{conns: make(chan net.Conn), closed: make(chan struct{})}
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	select {
+	case <-l.closed:
+	default:
+		close(l.closed)
+	}
+
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return &net.TCPAddr{}
+}
+
+// connect a client to the server with an in-memory connection
+func (l *pipeListener) dial() net.Conn {
+	client, server := net.Pipe()
+	l.conns <- server
+
+	return client
+}
+
+func TestHTTP2Server(t *testing.T) {
+	router := NewRouter()
+	router.HandleFunc("POST /echo", func(c Context) *Response {
+		return NewTextResponse(c.Request.Method+" "+c.Request.Version+" "+c.Request.Body).SetHeader("X-Test", "ok")
+	})
+
+	server := &Server{Router: router, HTTP2: &HTTP2Option{H2C: true}}
+	listener := newPipeListener()
+	go server.Serve(listener)
+
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		server.Shutdown(ctx)
+	}()
+
+	conn := listener.dial()
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// net.Pipe is not buffered, so the frames of the server are read while the request is written
+	frames := make(chan http2Frame, 16)
+	go func() {
+		defer close(frames)
+
+		for {
+			frame, err := readHTTP2Frame(conn, http2MaxFrameSizeLimit)
+			if err != nil {
+				return
+			}
+
+			frames <- frame
+		}
+	}()
+
+	body := "hello http2"
+	block := newHpackEncoder().encode(nil, []hpackField{
+		{":method", "POST"},
+		{":scheme", "http"},
+		{":path", "/echo"},
+		{":authority", "example.com"},
+		{"content-length", strconv.Itoa(len(body))},
+	})
+
+	if _, err := conn.Write([]byte(http2Preface)); err != nil {
+		t.Fatal(err)
+	}
+
+	writes := []struct {
+		typ      http2FrameType
+		flags    uint8
+		streamID uint32
+		payload  []byte
+	}{
+		{http2FrameSettings, 0, 0, nil},
+		{http2FrameHeaders, http2FlagEndHeaders, 1, block},
+		{http2FrameData, http2FlagEndStream, 1, []byte(body)},
+	}
+
+	for _, w := range writes {
+		if err := writeHTTP2Frame(conn, w.typ, w.flags, w.streamID, w.payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	decoder := newHpackDecoder(4096)
+	headers := make(map[string]string)
+	var data []byte
+	settingsAck := false
+
+	for frame := range frames {
+		switch {
+		case frame.Type == http2FrameSettings && frame.has(http2FlagAck):
+			settingsAck = true
+		case frame.Type == http2FrameHeaders && frame.StreamID == 1:
+			fields, err := decoder.decode(frame.Payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, f := range fields {
+				headers[f.name] = f.value
+			}
+		case frame.Type == http2FrameData && frame.StreamID == 1:
+			data = append(data, frame.Payload...)
+		case frame.Type == http2FrameRSTStream || frame.Type == http2FrameGoAway:
+			t.Fatalf("unexpected frame %d", frame.Type)
+		}
+
+		if frame.StreamID == 1 && frame.has(http2FlagEndStream) {
+			break
+		}
+	}
+
+	if !settingsAck {
+		t.Error("SETTINGS is not acknowledged")
+	}
+
+	if headers[":status"] != "200" || headers["x-test"] != "ok" {
+		t.Errorf("unexpected headers %v", headers)
+	}
+
+	if want := "POST HTTP/2.0 " + body; string(data) != want {
+		t.Errorf("got body %q, want %q", data, want)
+	}
+}
+
+// nothing is written for a stream after it is reset, the RST_STREAM must be the last frame
+func TestHTTP2WriteAfterReset(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	sc := newHTTP2Conn(context.Background(), NewRouter(), server, DefaultHTTP2Option.normalize())
+	st := sc.newStream(1)
+	st.req = &Request{Method: "GET"}
+
+	sc.mu.Lock()
+	sc.streams[1] = st
+	sc.removeStream(st)
+	sc.mu.Unlock()
+
+	written := make(chan error, 1)
+	go func() {
+		client.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+
+		_, err := readHTTP2Frame(client, http2MaxFrameSizeLimit)
+		written <- err
+	}()
+
+	err := sc.writeResponse(st, NewTextResponse("too late"))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+
+	if err := <-written; err == nil {
+		t.Error("a frame is written after the stream is reset")
+	}
+}
+
+// connection that is driven by calling processFrame, the frames of the server are discarded
+func newTestHTTP2Conn(t *testing.T, router *Router, option HTTP2Option) *http2Conn {
+	client, server := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+
+	go func() {
+		for {
+			if _, err := readHTTP2Frame(client, http2MaxFrameSizeLimit); err != nil {
+				return
+			}
+		}
+	}()
+
+	sc := newHTTP2Conn(context.Background(), router, server, option.normalize())
+	t.Cleanup(sc.close)
+
+	return sc
+}
+
+func testHTTP2Frame(typ http2FrameType, flags uint8, streamID uint32, payload []byte) http2Frame {
+	return http2Frame{Type: typ, Flags: flags, StreamID: streamID, Payload: payload}
+}
+
+func testHTTP2Headers(streamID uint32, endStream bool) http2Frame {
+	block := newHpackEncoder().encode(nil, []hpackField{
+		{":method", "POST"},
+		{":scheme", "http"},
+		{":path", "/"},
+		{":authority", "example.com"},
+	})
+
+	flags := http2FlagEndHeaders
+	if endStream {
+		flags |= http2FlagEndStream
+	}
+
+	return testHTTP2Frame(http2FrameHeaders, flags, streamID, block)
+}
+
+func testHTTP2Reset(streamID uint32) http2Frame {
+	return testHTTP2Frame(http2FrameRSTStream, 0, streamID, binary.BigEndian.AppendUint32(nil, uint32(http2ErrCancel)))
+}
+
+func waitHTTP2Active(t *testing.T, sc *http2Conn, active uint32) {
+	t.Helper()
+
+	for i := 0; i < 100; i++ {
+		sc.mu.Lock()
+		got := sc.active
+		sc.mu.Unlock()
+
+		if got == active {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("active streams is not %d", active)
+}
+
+// the stream that is reset while its handler is running still count toward the max concurrent streams
+func TestHTTP2RapidReset(t *testing.T) {
+	release := make(chan struct{})
+
+	router := NewRouter()
+	router.HandleFunc("POST /", func(c Context) *Response {
+		<-release
+		return NewTextResponse("ok")
+	})
+
+	sc := newTestHTTP2Conn(t, router, HTTP2Option{MaxConcurrentStreams: 1})
+
+	// the handlers must return before the connection is closed by the cleanup
+	unblock := sync.OnceFunc(func() { close(release) })
+	t.Cleanup(unblock)
+
+	if err := sc.processFrame(testHTTP2Headers(1, true)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sc.processFrame(testHTTP2Reset(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	err := sc.processFrame(testHTTP2Headers(3, true))
+	if want := (http2StreamError{3, http2ErrRefusedStream}); err != want {
+		t.Fatalf("got %v, want %v", err, want)
+	}
+
+	unblock()
+	waitHTTP2Active(t, sc, 0)
+
+	if err := sc.processFrame(testHTTP2Headers(5, true)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// the request bodies that are held by a connection are limited by MaxBufferedBodySize
+func TestHTTP2MaxBufferedBodySize(t *testing.T) {
+	router := NewRouter()
+	router.HandleFunc("POST /", func(c Context) *Response {
+		return NewTextResponse(c.Request.Body)
+	})
+
+	sc := newTestHTTP2Conn(t, router, HTTP2Option{MaxRequestBodySize: 16, MaxBufferedBodySize: 16})
+	body := []byte("0123456789")
+
+	frames := []http2Frame{
+		testHTTP2Headers(1, false),
+		testHTTP2Frame(http2FrameData, 0, 1, body),
+		testHTTP2Headers(3, false),
+	}
+
+	for _, frame := range frames {
+		if err := sc.processFrame(frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := sc.processFrame(testHTTP2Frame(http2FrameData, 0, 3, body))
+	if want := (http2StreamError{3, http2ErrRefusedStream}); err != want {
+		t.Fatalf("got %v, want %v", err, want)
+	}
+	sc.resetStream(3, http2ErrRefusedStream)
+
+	// the body of the reset stream is released
+	frames = []http2Frame{
+		testHTTP2Reset(1),
+		testHTTP2Headers(5, false),
+		testHTTP2Frame(http2FrameData, http2FlagEndStream, 5, body),
+	}
+
+	for _, frame := range frames {
+		if err := sc.processFrame(frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	waitHTTP2Active(t, sc, 0)
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.buffered != 0 {
+		t.Fatalf("%d bytes are still buffered", sc.buffered)
+	}
+}
@@ -0,0 +1,119 @@
+package chttp
+
+import (
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// http server instrumentation, use [Metrics.Middleware] for request metrics
+// and set [Server.Metrics] for connection and traffic metrics
+type Metrics struct {
+	Registry *Registry
+
+	requests         *Counter
+	duration         *Histogram
+	inFlight         *Gauge
+	openConnections  *Gauge
+	connectionsTotal *Counter
+	bytesReceived    *Counter
+	bytesSent        *Counter
+}
+
+// create http metrics on the registry, [DefaultRegistry] will be used if registry is nil
+func NewMetrics(registry *Registry) *Metrics {
+	if registry == nil {
+		registry = DefaultRegistry
+	}
+
+	return &Metrics{
+		Registry:         registry,
+		requests:         registry.NewCounter("chttp_requests", "Total number of HTTP requests.", "method", "route", "status"),
+		duration:         registry.NewHistogram("chttp_request_duration_seconds", "HTTP request latency in seconds.", nil, "method", "route", "status"),
+		inFlight:         registry.NewGauge("chttp_requests_in_flight", "Number of HTTP requests being served."),
+		openConnections:  registry.NewGauge("chttp_open_connections", "Number of open connections."),
+		connectionsTotal: registry.NewCounter("chttp_connections", "Total number of accepted connections."),
+		bytesReceived:    registry.NewCounter("chttp_received_bytes", "Total number of bytes received."),
+		bytesSent:        registry.NewCounter("chttp_sent_bytes", "Total number of bytes sent."),
+	}
+}
+
+// middleware that record request count, latency and in-flight requests.
+// requests without a matching route will be labeled as "unmatched" to keep the cardinality low
+func (m *Metrics) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return func(c Context) *Response {
+			start := time.Now()
+			m.inFlight.Inc()
+
+			code := 500
+			defer func() {
+				m.inFlight.Dec()
+
+				route := c.Pattern
+				if route == "" {
+					route = "unmatched"
+				}
+
+				status := strconv.Itoa(code)
+				m.requests.Inc(c.Request.Method, route, status)
+				m.duration.Observe(time.Since(start).Seconds(), c.Request.Method, route, status)
+			}()
+
+			resp := next(c)
+
+			code = 200
+			if resp != nil && resp.Code != 0 {
+				code = resp.Code
+			}
+
+			return resp
+		}
+	}
+}
+
+// handler that expose the registry in OpenMetrics text format
+func (m *Metrics) Handler() Handler {
+	return m.Registry.Handler()
+}
+
+// wrap the connection to count bytes, the open connections gauge is decreased on close
+func (m *Metrics) instrumentConn(conn net.Conn) net.Conn {
+	m.openConnections.Inc()
+	m.connectionsTotal.Inc()
+
+	return &countingConn{Conn: conn, metrics: m}
+}
+
+type countingConn struct {
+	net.Conn
+	metrics *Metrics
+	closed  atomic.Bool
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.metrics.bytesReceived.Add(float64(n))
+	}
+
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.metrics.bytesSent.Add(float64(n))
+	}
+
+	return n, err
+}
+
+func (c *countingConn) Close() error {
+	if c.closed.CompareAndSwap(false, true) {
+		c.metrics.openConnections.Dec()
+	}
+
+	return c.Conn.Close()
+}
@@ -0,0 +1,358 @@
+package chttp
+
+import (
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const OpenMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// default histogram buckets in seconds
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+var DefaultRegistry = NewRegistry()
+
+type metric interface {
+	name() string
+	write(b *strings.Builder)
+}
+
+// a collection of metrics that can be exposed in OpenMetrics text format
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+	names   map[string]struct{}
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		names: make(map[string]struct{}),
+	}
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.names[m.name()]; ok {
+		panic("metric " + m.name() + " is already registered")
+	}
+
+	r.names[m.name()] = struct{}{}
+	r.metrics = append(r.metrics, m)
+}
+
+// write every metric in OpenMetrics text format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := make([]metric, len(r.metrics))
+	copy(metrics, r.metrics)
+	r.mu.Unlock()
+
+	var b strings.Builder
+	for _, m := range metrics {
+		m.write(&b)
+	}
+	b.WriteString("# EOF\n")
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// handler that expose the registry, mount it with router.HandleFunc("/metrics", registry.Handler())
+func (r *Registry) Handler() Handler {
+	return func(c Context) *Response {
+		var b strings.Builder
+		r.WriteTo(&b)
+
+		return NewResponse().SetHeader("Content-Type", OpenMetricsContentType).SetBody(b.String())
+	}
+}
+
+// values of a metric, keyed by the joined label values
+type series[T any] struct {
+	mu     sync.Mutex
+	labels []string
+	values map[string]*T
+	keys   map[string][]string
+}
+
+func newSeries[T any](labels []string) series[T] {
+	return series[T]{
+		labels: labels,
+		values: make(map[string]*T),
+		keys:   make(map[string][]string),
+	}
+}
+
+// get or create value for the label values, caller must hold the lock
+func (s *series[T]) get(labelValues []string, create func() *T) *T {
+	if len(labelValues) != len(s.labels) {
+		panic("invalid number of label values")
+	}
+
+	key := strings.Join(labelValues, "\xff")
+	value, ok := s.values[key]
+	if !ok {
+		value = create()
+		s.values[key] = value
+		s.keys[key] = append([]string(nil), labelValues...)
+	}
+
+	return value
+}
+
+// sorted keys for stable output, caller must hold the lock
+func (s *series[T]) sortedKeys() []string {
+	keys := make([]string, 0, len(s.values))
+	for key := range s.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+type Counter struct {
+	metricName string
+	help       string
+	series     series[float64]
+}
+
+// create a counter, the name should not have _total suffix, it will be added on exposition
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{
+		metricName: strings.TrimSuffix(name, "_total"),
+		help:       help,
+		series:     newSeries[float64](labels),
+	}
+	if len(labels) == 0 {
+		c.series.get(nil, newFloat)
+	}
+	r.register(c)
+
+	return c
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// add value to the counter, negative value will be ignored
+func (c *Counter) Add(value float64, labelValues ...string) {
+	if value < 0 {
+		return
+	}
+
+	c.series.mu.Lock()
+	*c.series.get(labelValues, newFloat) += value
+	c.series.mu.Unlock()
+}
+
+func (c *Counter) name() string {
+	return c.metricName
+}
+
+func (c *Counter) write(b *strings.Builder) {
+	writeMeta(b, c.metricName, "counter", c.help)
+
+	c.series.mu.Lock()
+	defer c.series.mu.Unlock()
+
+	for _, key := range c.series.sortedKeys() {
+		writeSample(b, c.metricName+"_total", c.series.labels, c.series.keys[key], "", "", *c.series.values[key])
+	}
+}
+
+type Gauge struct {
+	metricName string
+	help       string
+	series     series[float64]
+}
+
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{
+		metricName: name,
+		help:       help,
+		series:     newSeries[float64](labels),
+	}
+	if len(labels) == 0 {
+		g.series.get(nil, newFloat)
+	}
+	r.register(g)
+
+	return g
+}
+
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.series.mu.Lock()
+	*g.series.get(labelValues, newFloat) = value
+	g.series.mu.Unlock()
+}
+
+func (g *Gauge) Add(value float64, labelValues ...string) {
+	g.series.mu.Lock()
+	*g.series.get(labelValues, newFloat) += value
+	g.series.mu.Unlock()
+}
+
+func (g *Gauge) Inc(labelValues ...string) {
+	g.Add(1, labelValues...)
+}
+
+func (g *Gauge) Dec(labelValues ...string) {
+	g.Add(-1, labelValues...)
+}
+
+func (g *Gauge) name() string {
+	return g.metricName
+}
+
+func (g *Gauge) write(b *strings.Builder) {
+	writeMeta(b, g.metricName, "gauge", g.help)
+
+	g.series.mu.Lock()
+	defer g.series.mu.Unlock()
+
+	for _, key := range g.series.sortedKeys() {
+		writeSample(b, g.metricName, g.series.labels, g.series.keys[key], "", "", *g.series.values[key])
+	}
+}
+
+type histogramValue struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+type Histogram struct {
+	metricName string
+	help       string
+	buckets    []float64
+	series     series[histogramValue]
+}
+
+// create a histogram, [DefaultBuckets] will be used if buckets is nil
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+
+	h := &Histogram{
+		metricName: name,
+		help:       help,
+		buckets:    buckets,
+		series:     newSeries[histogramValue](labels),
+	}
+	if len(labels) == 0 {
+		h.series.get(nil, h.newValue)
+	}
+	r.register(h)
+
+	return h
+}
+
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	h.series.mu.Lock()
+	defer h.series.mu.Unlock()
+
+	v := h.series.get(labelValues, h.newValue)
+
+	for i, bucket := range h.buckets {
+		if value <= bucket {
+			v.counts[i]++
+		}
+	}
+	v.count++
+	v.sum += value
+}
+
+func (h *Histogram) newValue() *histogramValue {
+	return &histogramValue{counts: make([]uint64, len(h.buckets))}
+}
+
+func (h *Histogram) name() string {
+	return h.metricName
+}
+
+func (h *Histogram) write(b *strings.Builder) {
+	writeMeta(b, h.metricName, "histogram", h.help)
+
+	h.series.mu.Lock()
+	defer h.series.mu.Unlock()
+
+	for _, key := range h.series.sortedKeys() {
+		v := h.series.values[key]
+		labelValues := h.series.keys[key]
+
+		for i, bucket := range h.buckets {
+			writeSample(b, h.metricName+"_bucket", h.series.labels, labelValues, "le", formatFloat(bucket), float64(v.counts[i]))
+		}
+		writeSample(b, h.metricName+"_bucket", h.series.labels, labelValues, "le", "+Inf", float64(v.count))
+		writeSample(b, h.metricName+"_count", h.series.labels, labelValues, "", "", float64(v.count))
+		writeSample(b, h.metricName+"_sum", h.series.labels, labelValues, "", "", v.sum)
+	}
+}
+
+func newFloat() *float64 {
+	return new(float64)
+}
+
+func writeMeta(b *strings.Builder, name, metricType, help string) {
+	b.WriteString("# TYPE " + name + " " + metricType + "\n")
+	if help != "" {
+		b.WriteString("# HELP " + name + " " + escapeLabel(help) + "\n")
+	}
+}
+
+func writeSample(b *strings.Builder, name string, labels, labelValues []string, extraLabel, extraValue string, value float64) {
+	b.WriteString(name)
+
+	if len(labels) > 0 || extraLabel != "" {
+		b.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(label + "=\"" + escapeLabel(labelValues[i]) + "\"")
+		}
+
+		if extraLabel != "" {
+			if len(labels) > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(extraLabel + "=\"" + extraValue + "\"")
+		}
+		b.WriteByte('}')
+	}
+
+	b.WriteString(" " + formatFloat(value) + "\n")
+}
+
+func escapeLabel(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+
+	return s
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
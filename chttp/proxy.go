@@ -0,0 +1,512 @@
+package chttp
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrNoUpstream = errors.New("no healthy upstream")
+
+type LoadBalancing int
+
+const (
+	RoundRobin LoadBalancing = iota
+	// pick the upstream with the fewest active requests
+	LeastConnections
+)
+
+// hop-by-hop headers that is not forwarded, see RFC 9110 Section 7.6.1
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Connection",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+type ProxyOption struct {
+	// upstream base urls, e.g. "http://10.0.0.1:8080", the url path is prepended to the request path
+	Upstreams     []string
+	LoadBalancing LoadBalancing
+	// upstream is skipped for FailTimeout after the consecutive failures, default is 3
+	MaxFails int
+	// default is 10 seconds
+	FailTimeout time.Duration
+	// default is 5 seconds
+	DialTimeout time.Duration
+	// max time to wait for the response headers, default is 30 seconds
+	ResponseHeaderTimeout time.Duration
+	// send the Host of the request instead of the upstream host
+	PreserveHost bool
+	// append to X-Forwarded-* and Forwarded from the client instead of replacing them,
+	// only enable it if the proxy is behind another trusted proxy
+	TrustForwardedHeaders bool
+	// used to connect https upstreams
+	TLSConfig *tls.Config
+	// modify the request before it is sent to the upstream
+	Rewrite func(c Context, req *Request)
+	// response when the upstream cannot be reached, default is 502 Bad Gateway,
+	// 503 Service Unavailable when every upstream is unhealthy or 504 Gateway Timeout
+	ErrorHandler ErrHandler
+}
+
+// forward requests to upstream HTTP/1.1 servers, the bodies and websocket connection are streamed.
+// the request body is only streamed if the route has [Route.StreamBody], otherwise it is sent from [Request.Body]
+type ReverseProxy struct {
+	option    ProxyOption
+	upstreams []*upstream
+	next      atomic.Uint64
+}
+
+type upstream struct {
+	url    *url.URL
+	addr   string
+	active atomic.Int64
+
+	mu        sync.Mutex
+	fails     int
+	downUntil time.Time
+}
+
+func NewReverseProxy(option *ProxyOption) (*ReverseProxy, error) {
+	if option == nil || len(option.Upstreams) == 0 {
+		return nil, errors.New("at least one upstream is required")
+	}
+
+	o := *option
+	if o.MaxFails <= 0 {
+		o.MaxFails = 3
+	}
+
+	if o.FailTimeout <= 0 {
+		o.FailTimeout = 10 * time.Second
+	}
+
+	if o.DialTimeout <= 0 {
+		o.DialTimeout = 5 * time.Second
+	}
+
+	if o.ResponseHeaderTimeout <= 0 {
+		o.ResponseHeaderTimeout = 30 * time.Second
+	}
+
+	if o.ErrorHandler == nil {
+		o.ErrorHandler = proxyError
+	}
+
+	p := &ReverseProxy{option: o}
+	for _, raw := range o.Upstreams {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, err
+		}
+
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return nil, errors.New("upstream scheme must be http or https: " + raw)
+		}
+
+		addr := u.Host
+		if u.Port() == "" {
+			port := "80"
+			if u.Scheme == "https" {
+				port = "443"
+			}
+			addr = net.JoinHostPort(u.Hostname(), port)
+		}
+
+		p.upstreams = append(p.upstreams, &upstream{url: u, addr: addr})
+	}
+
+	return p, nil
+}
+
+// handler that forward the request, register it with [Router.HandleFuncStream] or use it as
+// [Router.NotFound] with [Router.NotFoundStreamBody] to forward every unmatched request
+func (p *ReverseProxy) Handler() Handler {
+	return p.serve
+}
+
+func (p *ReverseProxy) serve(c Context) *Response {
+	var tried []*upstream
+	var lastErr error
+
+	// retry another upstream only if the connection failed, nothing is sent yet
+	for range p.upstreams {
+		up := p.pick(tried)
+		if up == nil {
+			break
+		}
+		tried = append(tried, up)
+
+		conn, err := p.dial(c, up)
+		if err != nil {
+			up.fail(p.option.MaxFails, p.option.FailTimeout)
+			lastErr = err
+
+			continue
+		}
+
+		return p.forward(c, up, conn)
+	}
+
+	if lastErr == nil {
+		lastErr = ErrNoUpstream
+	}
+
+	return p.option.ErrorHandler(c, lastErr)
+}
+
+func (p *ReverseProxy) pick(tried []*upstream) *upstream {
+	now := time.Now()
+	start := int(p.next.Add(1) - 1)
+
+	var best *upstream
+	for i := range p.upstreams {
+		up := p.upstreams[(start+i)%len(p.upstreams)]
+		if slices.Contains(tried, up) || !up.healthy(now) {
+			continue
+		}
+
+		if p.option.LoadBalancing == RoundRobin {
+			return up
+		}
+
+		if best == nil || up.active.Load() < best.active.Load() {
+			best = up
+		}
+	}
+
+	return best
+}
+
+func (p *ReverseProxy) dial(ctx context.Context, up *upstream) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: p.option.DialTimeout}
+
+	if up.url.Scheme == "https" {
+		config := &tls.Config{}
+		if p.option.TLSConfig != nil {
+			config = p.option.TLSConfig.Clone()
+		}
+
+		if config.ServerName == "" {
+			config.ServerName = up.url.Hostname()
+		}
+
+		return (&tls.Dialer{NetDialer: dialer, Config: config}).DialContext(ctx, "tcp", up.addr)
+	}
+
+	return dialer.DialContext(ctx, "tcp", up.addr)
+}
+
+func (p *ReverseProxy) forward(c Context, up *upstream, conn net.Conn) *Response {
+	up.active.Add(1)
+	release := sync.OnceFunc(func() {
+		up.active.Add(-1)
+		conn.Close()
+	})
+
+	// the handler context is only used until the response headers are received
+	stop := context.AfterFunc(c, func() { conn.Close() })
+	defer stop()
+
+	req := p.outgoing(c, up)
+	if err := req.Write(conn); err != nil {
+		release()
+		up.fail(p.option.MaxFails, p.option.FailTimeout)
+
+		return p.option.ErrorHandler(c, err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(p.option.ResponseHeaderTimeout))
+
+	br := bufio.NewReader(conn)
+	resp, _, err := readResponseHead(br)
+	if err != nil {
+		release()
+		up.fail(p.option.MaxFails, p.option.FailTimeout)
+
+		if c.Err() != nil {
+			err = c.Err()
+		}
+
+		return p.option.ErrorHandler(c, err)
+	}
+
+	conn.SetReadDeadline(time.Time{})
+	up.succeed()
+
+	// websocket pass-through, bytes are copied both ways until one side is closed
+	if resp.Code == 101 {
+		resp.Stream = tunnel(c.Conn, conn, br, release)
+		return resp
+	}
+
+	body, err := responseBodyReader(br, resp, c.Request.Method)
+	if err != nil {
+		release()
+		return p.option.ErrorHandler(c, err)
+	}
+	removeHopHeaders(resp.Headers)
+
+	// the response of HEAD or 304 keep the Content-Length of the upstream, so it is not written as an empty body
+	if body == nil {
+		release()
+		resp.Stream = func(ctx context.Context, w io.Writer) error { return nil }
+
+		return resp
+	}
+
+	resp.Stream = func(ctx context.Context, w io.Writer) error {
+		defer release()
+
+		stop := context.AfterFunc(ctx, release)
+		defer stop()
+
+		_, err := io.Copy(w, body)
+
+		return err
+	}
+
+	return resp
+}
+
+// copy the request for the upstream with forwarded headers
+func (p *ReverseProxy) outgoing(c Context, up *upstream) *Request {
+	in := c.Request
+
+	target := in.Path
+	if in.URL != nil {
+		target = in.URL.RequestURI()
+	} else if len(in.Args) > 0 {
+		target += "?" + encodeArgs(in.Args)
+	}
+
+	u, err := url.ParseRequestURI(strings.TrimSuffix(up.url.Path, "/") + target)
+	if err != nil {
+		u = &url.URL{Path: strings.TrimSuffix(up.url.Path, "/") + in.Path}
+	}
+
+	req := &Request{
+		Method:     in.Method,
+		Path:       u.Path,
+		Version:    "HTTP/1.1",
+		Body:       in.Body,
+		BodyReader: in.BodyReader,
+		Args:       in.Args,
+		Headers:    make(map[string]string, len(in.Headers)+4),
+		Cookie:     in.Cookie,
+		URL:        u,
+	}
+
+	for key, value := range in.Headers {
+		req.Headers[key] = value
+	}
+
+	upgrade := in.GetHeader("Upgrade")
+	removeHopHeaders(req.Headers)
+
+	if upgrade != "" && headerContainsToken(in.GetHeader("Connection"), "upgrade") {
+		req.Headers["Upgrade"] = upgrade
+		req.Headers["Connection"] = "Upgrade"
+	} else {
+		req.Headers["Connection"] = "close"
+	}
+
+	host := in.GetHeader("Host")
+	if !p.option.PreserveHost || host == "" {
+		deleteHeader(req.Headers, "Host")
+		req.Headers["Host"] = up.url.Host
+	}
+
+	p.setForwardedHeaders(c, req.Headers, host)
+
+	if p.option.Rewrite != nil {
+		p.option.Rewrite(c, req)
+	}
+
+	return req
+}
+
+func (p *ReverseProxy) setForwardedHeaders(c Context, headers map[string]string, host string) {
+	clientIP := c.ClientIP()
+
+	proto := "http"
+	if c.TLS() != nil {
+		proto = "https"
+	}
+
+	forwardedFor := clientIP
+	var forwarded string
+	if p.option.TrustForwardedHeaders {
+		if prior := c.Request.GetHeader("X-Forwarded-For"); prior != "" && clientIP != "" {
+			forwardedFor = prior + ", " + clientIP
+		} else if prior != "" {
+			forwardedFor = prior
+		}
+
+		forwarded = c.Request.GetHeader("Forwarded")
+	}
+
+	for _, key := range []string{"X-Forwarded-For", "X-Forwarded-Host", "X-Forwarded-Proto", "Forwarded"} {
+		deleteHeader(headers, key)
+	}
+
+	if forwardedFor != "" {
+		headers["X-Forwarded-For"] = forwardedFor
+	}
+
+	if host != "" {
+		headers["X-Forwarded-Host"] = host
+	}
+	headers["X-Forwarded-Proto"] = proto
+
+	// Forwarded from RFC 7239, ipv6 address and host with port must be quoted
+	var element []string
+	if clientIP != "" {
+		if strings.Contains(clientIP, ":") {
+			element = append(element, `for="[`+clientIP+`]"`)
+		} else {
+			element = append(element, "for="+clientIP)
+		}
+	}
+
+	if host != "" {
+		element = append(element, "host="+forwardedValue(host))
+	}
+	element = append(element, "proto="+proto)
+
+	if forwarded != "" {
+		forwarded += ", "
+	}
+	headers["Forwarded"] = forwarded + strings.Join(element, ";")
+}
+
+func forwardedValue(value string) string {
+	for _, r := range value {
+		if r == ':' || r == '[' || r == ']' || r == '"' || r == ';' || r == ',' || r == ' ' {
+			return `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+		}
+	}
+
+	return value
+}
+
+// copy the upgraded connection both ways, it return when one side is closed
+func tunnel(client io.ReadWriteCloser, upstream net.Conn, br *bufio.Reader, release func()) StreamFunc {
+	return func(ctx context.Context, w io.Writer) error {
+		defer release()
+
+		stop := context.AfterFunc(ctx, release)
+		defer stop()
+
+		errc := make(chan error, 2)
+		go func() {
+			_, err := io.Copy(w, br)
+			errc <- err
+		}()
+
+		go func() {
+			_, err := io.Copy(upstream, client)
+			errc <- err
+		}()
+
+		err := <-errc
+
+		// unblock the other side
+		release()
+		client.Close()
+		<-errc
+
+		return err
+	}
+}
+
+func removeHopHeaders(headers map[string]string) {
+	for _, key := range hopHeaders {
+		if key == "Connection" {
+			// headers that is listed in Connection are also hop-by-hop
+			for _, name := range strings.Split(getHeader(headers, key), ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					deleteHeader(headers, name)
+				}
+			}
+		}
+
+		deleteHeader(headers, key)
+	}
+}
+
+func getHeader(headers map[string]string, key string) string {
+	for k, value := range headers {
+		if strings.EqualFold(k, key) {
+			return value
+		}
+	}
+
+	return ""
+}
+
+func deleteHeader(headers map[string]string, key string) {
+	for k := range headers {
+		if strings.EqualFold(k, key) {
+			delete(headers, k)
+		}
+	}
+}
+
+func (u *upstream) healthy(now time.Time) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return !now.Before(u.downUntil)
+}
+
+// the upstream is skipped for timeout after maxFails consecutive failures
+func (u *upstream) fail(maxFails int, timeout time.Duration) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.fails++
+	if u.fails >= maxFails {
+		u.fails = 0
+		u.downUntil = time.Now().Add(timeout)
+
+		slog.Warn("Upstream is marked as unhealthy", "upstream", u.url.String(), "until", u.downUntil)
+	}
+}
+
+func (u *upstream) succeed() {
+	u.mu.Lock()
+	u.fails = 0
+	u.mu.Unlock()
+}
+
+func proxyError(c Context, err error) *Response {
+	slog.ErrorContext(c, "Error while proxying request", "err", err)
+
+	if errors.Is(err, ErrNoUpstream) {
+		return NewTextResponse("503 Service Unavailable").SetCode(503)
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return NewTextResponse("504 Gateway Timeout").SetCode(504)
+	}
+
+	return NewTextResponse("502 Bad Gateway").SetCode(502)
+}
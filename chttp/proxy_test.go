@@ -0,0 +1,199 @@
+package chttp
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http/httputil"
+	"net/textproto"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// upstream that is served by fn for every connection
+func newUpstream(t *testing.T, fn func(br *bufio.Reader, conn net.Conn)) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				fn(bufio.NewReader(conn), conn)
+			}()
+		}
+	}()
+
+	return "http://" + ln.Addr().String()
+}
+
+// serve a single request of the returned connection with the proxy as NotFound
+func newProxyConn(t *testing.T, upstream string) net.Conn {
+	t.Helper()
+
+	proxy, err := NewReverseProxy(&ProxyOption{Upstreams: []string{upstream}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := NewRouter()
+	router.NotFound = proxy.Handler()
+	router.NotFoundStreamBody = true
+
+	client, server := net.Pipe()
+	go func() {
+		defer server.Close()
+		router.ExecuteContext(context.Background(), server)
+	}()
+
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	t.Cleanup(func() { client.Close() })
+
+	return client
+}
+
+// the first chunk reaches the upstream before the client sends the rest of the body
+func TestProxyStreamRequestBody(t *testing.T) {
+	received := make(chan string, 1)
+
+	upstream := newUpstream(t, func(br *bufio.Reader, conn net.Conn) {
+		headers, err := readRequestHeaders(br)
+		if err != nil || headers.Get("Transfer-Encoding") != "chunked" {
+			t.Errorf("headers are %v, err %v", headers, err)
+			return
+		}
+
+		body := httputil.NewChunkedReader(br)
+
+		first := make([]byte, 6)
+		if _, err := io.ReadFull(body, first); err != nil {
+			t.Error(err)
+			return
+		}
+		received <- string(first)
+
+		rest, err := io.ReadAll(body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		echo := string(first) + string(rest)
+		conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: " + strconv.Itoa(len(echo)) + "\r\n\r\n" + echo))
+	})
+
+	conn := newProxyConn(t, upstream)
+	go conn.Write([]byte("POST /upload HTTP/1.1\r\nHost: example.com\r\nTransfer-Encoding: chunked\r\n\r\n6\r\nhello \r\n"))
+
+	select {
+	case first := <-received:
+		if first != "hello " {
+			t.Fatalf("first chunk is %q", first)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the first chunk is not streamed to the upstream")
+	}
+
+	if _, err := conn.Write([]byte("5\r\nworld\r\n0\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, _, err := readResponseHead(br)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := make([]byte, len("hello world"))
+	if _, err := io.ReadFull(br, body); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Code != 200 || string(body) != "hello world" {
+		t.Fatalf("response is %d %q", resp.Code, body)
+	}
+}
+
+// the request body with Content-Length is sent with the same length
+func TestProxyContentLength(t *testing.T) {
+	upstream := newUpstream(t, func(br *bufio.Reader, conn net.Conn) {
+		headers, err := readRequestHeaders(br)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		length, _ := strconv.Atoi(headers.Get("Content-Length"))
+		body := make([]byte, length)
+		if _, err := io.ReadFull(br, body); err != nil {
+			t.Error(err)
+			return
+		}
+
+		conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: " + strconv.Itoa(length) + "\r\n\r\n"))
+		conn.Write(body)
+	})
+
+	conn := newProxyConn(t, upstream)
+	go conn.Write([]byte("PUT /file HTTP/1.1\r\nHost: example.com\r\nContent-Length: 5\r\n\r\nhello"))
+
+	br := bufio.NewReader(conn)
+	resp, _, err := readResponseHead(br)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := io.ReadAll(br)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Headers["Content-Length"] != "5" || string(body) != "hello" {
+		t.Fatalf("response is %v %q", resp.Headers, body)
+	}
+}
+
+// the response without a body keep the Content-Length of the upstream
+func TestProxyHeadContentLength(t *testing.T) {
+	upstream := newUpstream(t, func(br *bufio.Reader, conn net.Conn) {
+		if _, err := readRequestHeaders(br); err != nil {
+			t.Error(err)
+			return
+		}
+
+		conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 42\r\n\r\n"))
+	})
+
+	conn := newProxyConn(t, upstream)
+	go conn.Write([]byte("HEAD /file HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+
+	resp, _, err := readResponseHead(bufio.NewReader(conn))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Headers["Content-Length"] != "42" {
+		t.Fatalf("Content-Length is %q, want 42", resp.Headers["Content-Length"])
+	}
+}
+
+func readRequestHeaders(br *bufio.Reader) (textproto.MIMEHeader, error) {
+	tp := textproto.NewReader(br)
+	if _, err := tp.ReadLine(); err != nil {
+		return nil, err
+	}
+
+	return tp.ReadMIMEHeader()
+}
@@ -0,0 +1,343 @@
+package chttp
+
+import (
+	"context"
+	"hash/fnv"
+	"log/slog"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type RateLimitAlgorithm int
+
+const (
+	// allow burst up to the limit, tokens are refilled evenly over the window
+	TokenBucket RateLimitAlgorithm = iota
+	// weighted counter of the current and previous window
+	SlidingWindow
+)
+
+type RateLimitRule struct {
+	// max requests per window
+	Limit     int
+	Window    time.Duration
+	Algorithm RateLimitAlgorithm
+}
+
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// time until the quota is fully restored
+	Reset time.Duration
+	// time until the next request will be allowed, only set if the request is not allowed
+	RetryAfter time.Duration
+}
+
+// rate limit state storage, implement it to share the limit between instances (e.g. redis)
+type RateLimitStore interface {
+	// take one request from the quota of the key
+	Take(ctx context.Context, key string, rule RateLimitRule) (RateLimitResult, error)
+}
+
+type RateLimitKeyFunc func(c Context) string
+
+// limit by the client ip address
+func KeyByIP(c Context) string {
+	return c.ClientIP()
+}
+
+// limit by the header value, request without the header will not be limited
+func KeyByHeader(name string) RateLimitKeyFunc {
+	return func(c Context) string {
+		return c.Request.GetHeader(name)
+	}
+}
+
+type RateLimitOption struct {
+	Rule RateLimitRule
+	// a new [MemoryRateLimitStore] will be used if nil
+	Store RateLimitStore
+	// [KeyByIP] will be used if nil, empty key will not be limited
+	KeyFunc RateLimitKeyFunc
+	// response when the limit is reached, default is 429 Too Many Requests
+	LimitReached Handler
+}
+
+var DefaultRateLimitOption = RateLimitOption{
+	Rule: RateLimitRule{
+		Limit:     60,
+		Window:    time.Minute,
+		Algorithm: TokenBucket,
+	},
+}
+
+// limit requests per key and set RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset
+// and RateLimit-Policy headers. if the store return an error, the request will be allowed
+func RateLimitMiddleware(option *RateLimitOption) Middleware {
+	if option == nil {
+		option = &DefaultRateLimitOption
+	}
+
+	rule := option.Rule
+	if rule.Limit <= 0 || rule.Window <= 0 {
+		panic("rate limit rule must have positive limit and window")
+	}
+
+	store := option.Store
+	if store == nil {
+		store = NewMemoryRateLimitStore()
+	}
+
+	keyFunc := option.KeyFunc
+	if keyFunc == nil {
+		keyFunc = KeyByIP
+	}
+
+	limitReached := option.LimitReached
+	if limitReached == nil {
+		limitReached = func(c Context) *Response {
+			return NewTextResponse("429 Too Many Requests").SetCode(429)
+		}
+	}
+
+	policy := strconv.Itoa(rule.Limit) + ";w=" + strconv.Itoa(int(math.Ceil(rule.Window.Seconds())))
+
+	return func(next Handler) Handler {
+		return func(c Context) *Response {
+			key := keyFunc(c)
+			if key == "" {
+				return next(c)
+			}
+
+			result, err := store.Take(c, key, rule)
+			if err != nil {
+				slog.WarnContext(c, "Rate limit store error", "err", err)
+
+				return next(c)
+			}
+
+			var resp *Response
+			if result.Allowed {
+				resp = next(c)
+			} else {
+				resp = limitReached(c)
+			}
+
+			if resp == nil {
+				resp = NewResponse()
+			}
+
+			if resp.Headers == nil {
+				resp.Headers = make(map[string]string)
+			}
+
+			resp.SetHeader("RateLimit-Limit", strconv.Itoa(result.Limit))
+			resp.SetHeader("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			resp.SetHeader("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+			resp.SetHeader("RateLimit-Policy", policy)
+
+			if !result.Allowed {
+				resp.SetHeader("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+			}
+
+			return resp
+		}
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+
+	return int(math.Ceil(d.Seconds()))
+}
+
+const rateLimitShards = 32
+
+// in memory store, the keys are split into shards to reduce lock contention.
+// idle keys are removed periodically
+type MemoryRateLimitStore struct {
+	shards [rateLimitShards]*rateLimitShard
+	// used to get current time, it can be replaced in tests
+	Now func() time.Time
+}
+
+type rateLimitShard struct {
+	mu        sync.Mutex
+	entries   map[string]*rateLimitEntry
+	lastSweep time.Time
+}
+
+type rateLimitEntry struct {
+	// token bucket
+	tokens float64
+	last   time.Time
+
+	// sliding window
+	windowStart time.Time
+	current     int
+	previous    int
+
+	expire time.Time
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	store := &MemoryRateLimitStore{
+		Now: time.Now,
+	}
+
+	for i := range store.shards {
+		store.shards[i] = &rateLimitShard{
+			entries: make(map[string]*rateLimitEntry),
+		}
+	}
+
+	return store
+}
+
+func (s *MemoryRateLimitStore) Take(ctx context.Context, key string, rule RateLimitRule) (RateLimitResult, error) {
+	now := s.Now()
+
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	shard := s.shards[h.Sum32()%rateLimitShards]
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	shard.sweep(now, rule.Window)
+
+	entry, ok := shard.entries[key]
+	if !ok {
+		entry = &rateLimitEntry{
+			tokens:      float64(rule.Limit),
+			last:        now,
+			windowStart: now,
+		}
+		shard.entries[key] = entry
+	}
+
+	var result RateLimitResult
+	switch rule.Algorithm {
+	case SlidingWindow:
+		result = entry.slidingWindow(now, rule)
+	default:
+		result = entry.tokenBucket(now, rule)
+	}
+
+	// keep the entry until the quota is fully restored
+	entry.expire = now.Add(result.Reset)
+
+	return result, nil
+}
+
+// remove expired entries at most once per window
+func (s *rateLimitShard) sweep(now time.Time, window time.Duration) {
+	if now.Sub(s.lastSweep) < window {
+		return
+	}
+	s.lastSweep = now
+
+	for key, entry := range s.entries {
+		if now.After(entry.expire) {
+			delete(s.entries, key)
+		}
+	}
+}
+
+func (e *rateLimitEntry) tokenBucket(now time.Time, rule RateLimitRule) RateLimitResult {
+	limit := float64(rule.Limit)
+	rate := limit / rule.Window.Seconds()
+
+	elapsed := now.Sub(e.last).Seconds()
+	if elapsed > 0 {
+		e.tokens = math.Min(limit, e.tokens+elapsed*rate)
+		e.last = now
+	}
+
+	result := RateLimitResult{
+		Limit: rule.Limit,
+	}
+
+	if e.tokens >= 1 {
+		e.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = secondsToDuration((1 - e.tokens) / rate)
+	}
+
+	result.Remaining = int(math.Floor(e.tokens))
+	result.Reset = secondsToDuration((limit - e.tokens) / rate)
+
+	return result
+}
+
+func (e *rateLimitEntry) slidingWindow(now time.Time, rule RateLimitRule) RateLimitResult {
+	window := rule.Window
+
+	// move to the current window
+	elapsed := now.Sub(e.windowStart)
+	if elapsed >= window {
+		windows := elapsed / window
+		if windows == 1 {
+			e.previous = e.current
+		} else {
+			e.previous = 0
+		}
+		e.current = 0
+		e.windowStart = e.windowStart.Add(windows * window)
+		elapsed = now.Sub(e.windowStart)
+	}
+
+	weight := 1 - float64(elapsed)/float64(window)
+	estimate := float64(e.previous)*weight + float64(e.current)
+
+	result := RateLimitResult{
+		Limit: rule.Limit,
+		Reset: window - elapsed,
+	}
+
+	if estimate+1 <= float64(rule.Limit) {
+		e.current++
+		estimate++
+		result.Allowed = true
+	} else {
+		result.RetryAfter = e.retryAfter(elapsed, rule)
+	}
+
+	result.Remaining = int(math.Max(0, math.Floor(float64(rule.Limit)-estimate)))
+
+	// previous window still count until the end of the next window
+	if e.current > 0 {
+		result.Reset = 2*window - elapsed
+	}
+
+	return result
+}
+
+// time until the weighted estimate allow one more request
+func (e *rateLimitEntry) retryAfter(elapsed time.Duration, rule RateLimitRule) time.Duration {
+	window := float64(rule.Window)
+	need := float64(rule.Limit - 1 - e.current)
+
+	if need >= 0 && e.previous > 0 {
+		wait := window*(1-need/float64(e.previous)) - float64(elapsed)
+		return time.Duration(math.Max(0, wait))
+	}
+
+	// current window is full, wait for the next window and its weighted count
+	wait := window - float64(elapsed)
+	if e.current > 0 {
+		wait += math.Max(0, window*(1-float64(rule.Limit-1)/float64(e.current)))
+	}
+
+	return time.Duration(wait)
+}
+
+func secondsToDuration(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
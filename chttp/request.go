@@ -1,84 +1,222 @@
 package chttp
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
 	"io"
+	"net"
+	"net/http/httputil"
+	"net/url"
+	"slices"
+	"strconv"
 	"strings"
 )
 
+// max size of the request line and headers
+const maxRequestHeaderSize = 1 << 20
+
+// max size of the request body that is read into [Request.Body]
+const maxRequestBodySize = 10 << 20
+
+var ErrRequestTooLarge = errors.New("request is too large")
+
 type Request struct {
 	Method  string
 	Path    string
 	Version string
 	Body    string
-	Args    map[string]string
-	Headers map[string]string
-	Cookie  map[string]string
+	// body of a route with [Route.StreamBody], it is read from the connection while the handler
+	// reads it and it is nil if the request has no body. [Request.Body] is empty in that case
+	BodyReader io.Reader
+	Args       map[string]string
+	Headers    map[string]string
+	Cookie     map[string]string
+	// parsed request target, the query is kept as it is sent
+	URL *url.URL
 }
 
+// get header value, if the exact key is not found, it will fallback to case-insensitive lookup
 func (r *Request) GetHeader(key string) string {
-	return r.Headers[key]
+	if value, ok := r.Headers[key]; ok {
+		return value
+	}
+
+	for k, value := range r.Headers {
+		if strings.EqualFold(k, key) {
+			return value
+		}
+	}
+
+	return ""
+}
+
+// get cookie value, it will be empty if the cookie doesnt exist
+func (r *Request) GetCookie(name string) string {
+	return r.Cookie[name]
 }
 
 func (r *Request) GetArgs(arg string) string {
 	return r.Args[arg]
 }
 
+// read a request from the connection, the body is read by Content-Length or chunked encoding
 func NewRequest(conn io.ReadWriteCloser) (request Request, err error) {
-	buf := make([]byte, 1024)
-
-	_, err = conn.Read(buf)
+	request, err = readRequestHead(conn)
 	if err != nil {
 		return request, err
 	}
 
-	stringBuf := string(buf)
+	err = readRequestBody(&request, conn)
 
-	sp := strings.Split(stringBuf, "\r\n")
+	return request, err
+}
 
-	requestLine := strings.Split(sp[0], " ")
-	request.Method = strings.ToUpper(requestLine[0])
-	request.Version = requestLine[2]
+// read the request line and headers, the bytes after the headers that are already read is kept in [Request.Body]
+func readRequestHead(conn io.Reader) (request Request, err error) {
+	var buf []byte
+	chunk := make([]byte, 1024)
 
-	request.Headers = make(map[string]string)
-	for i := 1; i < len(sp); i++ {
-		if sp[i] == "" {
-			request.Body = sp[i+1]
-			break
+	// read until the end of the headers
+	for !bytes.Contains(buf, []byte("\r\n\r\n")) {
+		n, err := conn.Read(chunk)
+		buf = append(buf, chunk[:n]...)
+
+		if len(buf) > maxRequestHeaderSize {
+			return request, ErrRequestTooLarge
 		}
 
-		header := strings.Split(sp[i], ": ")
-		request.Headers[header[0]] = header[1]
+		if err != nil {
+			if len(buf) > 0 && errors.Is(err, io.EOF) {
+				break
+			}
+
+			return request, err
+		}
 	}
 
-	// check if cookie exists in Headers
-	if request.Headers["Cookie"] != "" {
-		request.Cookie = parseCookie(request.Headers["Cookie"])
+	return NewRequestFromBuffer(buf)
+}
+
+// read the body after [readRequestHead] into [Request.Body]
+func readRequestBody(request *Request, conn io.Reader) error {
+	// reject it before the body is read
+	if length, err := strconv.ParseInt(request.GetHeader("Content-Length"), 10, 64); err == nil && length > maxRequestBodySize {
+		return ErrRequestTooLarge
 	}
 
-	// parse args
-	request.Path, request.Args = parseArgs(requestLine[1])
+	body, err := requestBodyReader(request, conn)
+	if err != nil || body == nil {
+		return err
+	}
 
-	return request, err
+	b, err := io.ReadAll(io.LimitReader(body, maxRequestBodySize+1))
+	if err != nil {
+		return err
+	}
+
+	if len(b) > maxRequestBodySize {
+		return ErrRequestTooLarge
+	}
+	request.Body = string(b)
+
+	return nil
+}
+
+// reader of the body after [readRequestHead] that is delimited by Content-Length or chunked encoding,
+// it is nil if the request has no body. the bytes in [Request.Body] are read first and it is cleared
+func requestBodyReader(request *Request, conn io.Reader) (io.Reader, error) {
+	chunked := strings.Contains(strings.ToLower(request.GetHeader("Transfer-Encoding")), "chunked")
+	value := request.GetHeader("Content-Length")
+
+	if !chunked && value == "" {
+		return nil, nil
+	}
+
+	body := io.MultiReader(strings.NewReader(request.Body), conn)
+	request.Body = ""
+
+	if chunked {
+		return httputil.NewChunkedReader(bufio.NewReader(body)), nil
+	}
+
+	length, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || length < 0 {
+		return nil, errors.New("invalid content length")
+	}
+
+	return &lengthReader{r: body, n: length}, nil
+}
+
+// set [Request.BodyReader] from the connection of c. the bytes after the headers of the request
+// without a body are returned by the next read of [Context.Conn], e.g. websocket frames
+func streamRequestBody(c *Context) error {
+	body, err := requestBodyReader(c.Request, c.Conn)
+	if err != nil {
+		return err
+	}
+
+	c.Request.BodyReader = body
+
+	leftover := c.Request.Body
+	c.Request.Body = ""
+
+	if netConn, ok := c.Conn.(net.Conn); ok && leftover != "" {
+		c.Conn = &bufferedConn{Conn: netConn, buf: []byte(leftover)}
+	}
+
+	return nil
+}
+
+// body with Content-Length, it is [io.ErrUnexpectedEOF] if the connection is closed before the end
+type lengthReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *lengthReader) Read(p []byte) (int, error) {
+	if l.n <= 0 {
+		return 0, io.EOF
+	}
+
+	if int64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+
+	if errors.Is(err, io.EOF) && l.n > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return n, err
 }
 
 func NewRequestFromBuffer(buf []byte) (request Request, err error) {
 	stringBuf := string(buf)
 
-	sp := strings.Split(stringBuf, "\r\n")
+	head, body, _ := strings.Cut(stringBuf, "\r\n\r\n")
+	request.Body = body
+
+	sp := strings.Split(head, "\r\n")
 
 	requestLine := strings.Split(sp[0], " ")
+	if len(requestLine) != 3 {
+		return request, errors.New("invalid request line")
+	}
+
 	request.Method = strings.ToUpper(requestLine[0])
 	request.Version = requestLine[2]
 
 	request.Headers = make(map[string]string)
 	for i := 1; i < len(sp); i++ {
-		if sp[i] == "" {
-			request.Body = sp[i+1]
-			break
+		key, value, ok := strings.Cut(sp[i], ":")
+		if !ok {
+			return request, errors.New("invalid header line")
 		}
 
-		header := strings.Split(sp[i], ": ")
-		request.Headers[header[0]] = header[1]
+		request.Headers[key] = strings.TrimSpace(value)
 	}
 
 	// check if cookie exists in Headers
@@ -89,7 +227,128 @@ func NewRequestFromBuffer(buf []byte) (request Request, err error) {
 	// parse args
 	request.Path, request.Args = parseArgs(requestLine[1])
 
-	return request, err
+	request.URL, err = url.ParseRequestURI(requestLine[1])
+	if err != nil {
+		request.URL = &url.URL{Path: request.Path}
+	}
+
+	return request, nil
+}
+
+// write the request in HTTP/1.1 format, the body is sent with Content-Length.
+// [Request.BodyReader] is sent instead of [Request.Body] if it is not nil
+func (r *Request) Write(w io.Writer) error {
+	method := r.Method
+	if method == "" {
+		method = "GET"
+	}
+
+	target := r.Path
+	if r.URL != nil {
+		target = r.URL.RequestURI()
+	} else if len(r.Args) > 0 {
+		target += "?" + encodeArgs(r.Args)
+	}
+
+	if target == "" {
+		target = "/"
+	}
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString(method + " " + target + " HTTP/1.1\r\n")
+
+	for key, value := range r.Headers {
+		if strings.EqualFold(key, "Content-Length") || strings.EqualFold(key, "Transfer-Encoding") {
+			continue
+		}
+
+		bw.WriteString(key + ": " + value + "\r\n")
+	}
+
+	// cookies that is set to the map are sent if the header is not set
+	if len(r.Cookie) > 0 && getHeader(r.Headers, "Cookie") == "" {
+		names := make([]string, 0, len(r.Cookie))
+		for name := range r.Cookie {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+
+		for i, name := range names {
+			names[i] = name + "=" + r.Cookie[name]
+		}
+
+		bw.WriteString("Cookie: " + strings.Join(names, "; ") + "\r\n")
+	}
+
+	if r.BodyReader != nil {
+		return r.writeBodyReader(bw)
+	}
+
+	if r.Body != "" || method == "POST" || method == "PUT" || method == "PATCH" {
+		bw.WriteString("Content-Length: " + strconv.Itoa(len(r.Body)) + "\r\n")
+	}
+
+	bw.WriteString("\r\n")
+	bw.WriteString(r.Body)
+
+	return bw.Flush()
+}
+
+// send [Request.BodyReader] with the Content-Length of the headers, or with chunked encoding if it is not set
+func (r *Request) writeBodyReader(bw *bufio.Writer) error {
+	if length := getHeader(r.Headers, "Content-Length"); length != "" {
+		bw.WriteString("Content-Length: " + length + "\r\n\r\n")
+
+		if _, err := io.Copy(flushWriter{bw, bw}, r.BodyReader); err != nil {
+			return err
+		}
+
+		return bw.Flush()
+	}
+
+	bw.WriteString("Transfer-Encoding: chunked\r\n\r\n")
+
+	cw := httputil.NewChunkedWriter(bw)
+	if _, err := io.Copy(flushWriter{cw, bw}, r.BodyReader); err != nil {
+		return err
+	}
+
+	// last chunk without trailer
+	cw.Close()
+	bw.WriteString("\r\n")
+
+	return bw.Flush()
+}
+
+// every read of the body is sent right away, the client may send the body slowly
+type flushWriter struct {
+	w  io.Writer
+	bw *bufio.Writer
+}
+
+func (f flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+
+	return n, f.bw.Flush()
+}
+
+func encodeArgs(args map[string]string) string {
+	keys := make([]string, 0, len(args))
+	for key := range args {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	for i, key := range keys {
+		if args[key] != "" {
+			keys[i] = key + "=" + args[key]
+		}
+	}
+
+	return strings.Join(keys, "&")
 }
 
 func parseCookie(cookie string) map[string]string {
@@ -97,8 +356,13 @@ func parseCookie(cookie string) map[string]string {
 	cookies := strings.Split(cookie, "; ")
 
 	for _, c := range cookies {
-		cookie := strings.Split(c, "=")
-		cookieMap[cookie[0]] = cookie[1]
+		// cookie value may contain "="
+		key, value, ok := strings.Cut(strings.TrimSpace(c), "=")
+		if !ok || key == "" {
+			continue
+		}
+
+		cookieMap[key] = strings.Trim(value, `"`)
 	}
 
 	return cookieMap
@@ -0,0 +1,338 @@
+package chttp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"strings"
+)
+
+const (
+	HeaderRequestID   = "X-Request-ID"
+	HeaderTraceparent = "traceparent"
+	HeaderTracestate  = "tracestate"
+)
+
+// max length of incoming request id, longer value will be replaced with a generated one
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+type traceContextKey struct{}
+
+// W3C trace context, see https://www.w3.org/TR/trace-context/
+type TraceContext struct {
+	TraceID string
+	SpanID  string
+	// span id of the caller, empty if the trace is started here
+	ParentSpanID string
+	Flags        byte
+	TraceState   string
+}
+
+type RequestIDOption struct {
+	// header used to read and echo the request id
+	Header string
+	// generate a new request id when the request doesn't have one
+	Generator func() string
+}
+
+var DefaultRequestIDOption = RequestIDOption{
+	Header:    HeaderRequestID,
+	Generator: NewRequestID,
+}
+
+// read or generate request id and parse trace context from the request headers,
+// both of them will be stored on [Context] and echoed in the response
+func RequestIDMiddleware(option *RequestIDOption) Middleware {
+	if option == nil {
+		option = &DefaultRequestIDOption
+	}
+
+	header := option.Header
+	if header == "" {
+		header = HeaderRequestID
+	}
+
+	generator := option.Generator
+	if generator == nil {
+		generator = NewRequestID
+	}
+
+	return func(next Handler) Handler {
+		return func(c Context) *Response {
+			id := c.Request.GetHeader(header)
+			if !validRequestID(id) {
+				id = generator()
+			}
+
+			// the router tracer may already continue the trace with a new span
+			trace, ok := TraceContextFromContext(c.Context)
+			if !ok {
+				trace = extractTraceContext(c.Request)
+				c.Context = WithTraceContext(c.Context, trace)
+			}
+
+			c.Context = WithRequestID(c.Context, id)
+
+			headers := map[string]string{
+				header:            id,
+				HeaderTraceparent: trace.Traceparent(),
+			}
+			if trace.TraceState != "" {
+				headers[HeaderTracestate] = trace.TraceState
+			}
+
+			// the router will handle the panic with the request id and trace context
+			defer func() {
+				if rc := recover(); rc != nil {
+					if _, ok := rc.(*requestPanic); !ok {
+						rc = &requestPanic{ctx: c.Context, headers: headers, value: rc}
+					}
+
+					panic(rc)
+				}
+			}()
+
+			resp := next(c)
+			if resp == nil {
+				resp = NewResponse()
+			}
+
+			setHeaders(resp, headers)
+
+			return resp
+		}
+	}
+}
+
+// panic of a handler inside [RequestIDMiddleware], ErrorHandler is called with ctx
+// and the headers are echoed in the error response
+type requestPanic struct {
+	ctx     context.Context
+	headers map[string]string
+	value   any
+}
+
+func setHeaders(resp *Response, headers map[string]string) {
+	if resp.Headers == nil {
+		resp.Headers = make(map[string]string)
+	}
+
+	for key, value := range headers {
+		resp.SetHeader(key, value)
+	}
+}
+
+// generate a random request id in uuid v4 format
+func NewRequestID() string {
+	b := randomBytes(16)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	s := hex.EncodeToString(b)
+	return s[0:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	// only allow visible ascii to avoid log injection
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+
+	return true
+}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func WithTraceContext(ctx context.Context, trace TraceContext) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, trace)
+}
+
+func TraceContextFromContext(ctx context.Context) (TraceContext, bool) {
+	trace, ok := ctx.Value(traceContextKey{}).(TraceContext)
+	return trace, ok
+}
+
+// get request id, it will be empty if [RequestIDMiddleware] is not used
+func (c Context) RequestID() string {
+	return RequestIDFromContext(c.Context)
+}
+
+// get trace context, it will be empty if [RequestIDMiddleware] is not used
+func (c Context) TraceContext() TraceContext {
+	trace, _ := TraceContextFromContext(c.Context)
+	return trace
+}
+
+// create a new trace context with random trace id and span id
+func NewTraceContext() TraceContext {
+	return TraceContext{
+		TraceID: hex.EncodeToString(randomBytes(16)),
+		SpanID:  hex.EncodeToString(randomBytes(8)),
+		Flags:   0x01,
+	}
+}
+
+// get trace context from the request headers, a new trace will be started
+// if the caller didnt send a valid traceparent. the trace id of the caller is kept,
+// but this server has its own span id with the caller span as the parent
+func extractTraceContext(req *Request) TraceContext {
+	trace, err := ParseTraceparent(req.GetHeader(HeaderTraceparent))
+	if err != nil {
+		return NewTraceContext()
+	}
+
+	trace.ParentSpanID = trace.SpanID
+	trace.SpanID = hex.EncodeToString(randomBytes(8))
+	trace.TraceState = req.GetHeader(HeaderTracestate)
+
+	return trace
+}
+
+// set traceparent and tracestate header from the trace context in ctx, use it to propagate the trace to other services
+func InjectTraceContext(ctx context.Context, headers map[string]string) {
+	trace, ok := TraceContextFromContext(ctx)
+	if !ok || !trace.IsValid() {
+		return
+	}
+
+	headers[HeaderTraceparent] = trace.Traceparent()
+	if trace.TraceState != "" {
+		headers[HeaderTracestate] = trace.TraceState
+	}
+}
+
+// parse traceparent header, the format is "version-traceid-parentid-flags"
+func ParseTraceparent(value string) (TraceContext, error) {
+	value = strings.TrimSpace(value)
+	if len(value) < 55 {
+		return TraceContext{}, errors.New("invalid traceparent length")
+	}
+
+	version, err := hex.DecodeString(value[0:2])
+	if err != nil || !isLowerHex(value[0:2]) || version[0] == 0xff {
+		return TraceContext{}, errors.New("invalid traceparent version")
+	}
+
+	// version 00 must have exact length, future version may append more fields
+	if version[0] == 0x00 && len(value) != 55 {
+		return TraceContext{}, errors.New("invalid traceparent length")
+	}
+
+	if len(value) > 55 && value[55] != '-' {
+		return TraceContext{}, errors.New("invalid traceparent format")
+	}
+
+	if value[2] != '-' || value[35] != '-' || value[52] != '-' {
+		return TraceContext{}, errors.New("invalid traceparent format")
+	}
+
+	trace := TraceContext{
+		TraceID: value[3:35],
+		SpanID:  value[36:52],
+	}
+
+	if !isLowerHex(trace.TraceID) || isZeroHex(trace.TraceID) {
+		return TraceContext{}, errors.New("invalid trace id")
+	}
+
+	if !isLowerHex(trace.SpanID) || isZeroHex(trace.SpanID) {
+		return TraceContext{}, errors.New("invalid parent id")
+	}
+
+	flags, err := hex.DecodeString(value[53:55])
+	if err != nil || !isLowerHex(value[53:55]) {
+		return TraceContext{}, errors.New("invalid trace flags")
+	}
+	trace.Flags = flags[0]
+
+	return trace, nil
+}
+
+func (t TraceContext) IsValid() bool {
+	return len(t.TraceID) == 32 && len(t.SpanID) == 16 && !isZeroHex(t.TraceID) && !isZeroHex(t.SpanID)
+}
+
+func (t TraceContext) Sampled() bool {
+	return t.Flags&0x01 != 0
+}
+
+// format trace context as traceparent header value
+func (t TraceContext) Traceparent() string {
+	return "00-" + t.TraceID + "-" + t.SpanID + "-" + hex.EncodeToString([]byte{t.Flags})
+}
+
+// slog handler that add request id and trace context from the log context,
+// use it with slog.InfoContext(c, ...) inside a handler
+type LogHandler struct {
+	handler slog.Handler
+}
+
+func NewLogHandler(handler slog.Handler) *LogHandler {
+	return &LogHandler{handler: handler}
+}
+
+func (h *LogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.handler.Enabled(ctx, level)
+}
+
+func (h *LogHandler) Handle(ctx context.Context, record slog.Record) error {
+	if ctx != nil {
+		if id := RequestIDFromContext(ctx); id != "" {
+			record.AddAttrs(slog.String("request_id", id))
+		}
+
+		if trace, ok := TraceContextFromContext(ctx); ok {
+			record.AddAttrs(slog.String("trace_id", trace.TraceID), slog.String("span_id", trace.SpanID))
+		}
+	}
+
+	return h.handler.Handle(ctx, record)
+}
+
+func (h *LogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &LogHandler{handler: h.handler.WithAttrs(attrs)}
+}
+
+func (h *LogHandler) WithGroup(name string) slog.Handler {
+	return &LogHandler{handler: h.handler.WithGroup(name)}
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return b
+}
+
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isZeroHex(s string) bool {
+	return strings.Trim(s, "0") == ""
+}
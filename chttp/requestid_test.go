@@ -0,0 +1,49 @@
+package chttp
+
+import (
+	"context"
+	"testing"
+)
+
+// the error handler of a panicking request has the request id and trace of the middleware
+func TestRequestIDPanic(t *testing.T) {
+	var got Context
+
+	router := NewRouter()
+	router.Use(RequestIDMiddleware(nil))
+	router.ErrorHandler = func(c Context, err error) *Response {
+		got = c
+		return NewTextResponse("500 Internal Server Error").SetCode(500)
+	}
+
+	router.HandleFunc("/panic", func(c Context) *Response {
+		panic("boom")
+	})
+
+	req := &Request{
+		Method:  "GET",
+		Path:    "/panic",
+		Headers: map[string]string{HeaderRequestID: "abc-123"},
+	}
+
+	resp, err := router.serve(Context{Context: context.Background(), Request: req})
+	if err == nil || err.Error() != "boom" {
+		t.Fatalf("err is %v, want boom", err)
+	}
+
+	if id := got.RequestID(); id != "abc-123" {
+		t.Fatalf("request id of the error handler is %q", id)
+	}
+
+	if !got.TraceContext().IsValid() {
+		t.Fatal("trace context of the error handler is not valid")
+	}
+
+	if resp.Headers[HeaderRequestID] != "abc-123" {
+		t.Fatalf("response headers are %v", resp.Headers)
+	}
+
+	if resp.Headers[HeaderTraceparent] != got.TraceContext().Traceparent() {
+		t.Fatalf("traceparent is %q, want %q", resp.Headers[HeaderTraceparent], got.TraceContext().Traceparent())
+	}
+}
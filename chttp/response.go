@@ -1,8 +1,14 @@
 package chttp
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"io"
+	"net/http/httputil"
+	"net/textproto"
 	"strconv"
+	"strings"
 )
 
 type Response struct {
@@ -11,8 +17,16 @@ type Response struct {
 	// please use [NewResponse] instead to avoid nil headers
 	Headers map[string]string
 	Body    string
+	// every cookie will be written as its own Set-Cookie header
+	Cookies []Cookie
+	// write the body after the headers are sent instead of Body, see [NewStreamResponse]
+	Stream StreamFunc
 }
 
+// write the response body progressively, every Write is sent to the client immediately.
+// ctx is cancelled when the client is disconnected
+type StreamFunc func(ctx context.Context, w io.Writer) error
+
 func NewResponse() *Response {
 	return &Response{
 		Code:    200,
@@ -56,6 +70,20 @@ func NewJSONResponse(json string) *Response {
 	}
 }
 
+// response that write the body with stream, the body length is unknown so
+// the connection is closed after the stream returns on HTTP/1.1
+func NewStreamResponse(contentType string, stream StreamFunc) *Response {
+	header := map[string]string{
+		"Content-Type": contentType,
+	}
+
+	return &Response{
+		Code:    200,
+		Headers: header,
+		Stream:  stream,
+	}
+}
+
 func (r *Response) SetHeader(key, value string) *Response {
 	r.Headers[key] = value
 
@@ -74,13 +102,40 @@ func (r *Response) SetCode(code int) *Response {
 	return r
 }
 
+// add a cookie with path and max age, zero max age will delete the cookie.
+// use [Response.AddCookie] to set other attributes
 func (r *Response) SetCookie(key, value, path string, maxAge int) *Response {
-	r.Headers["Set-Cookie"] = key + "=" + value + "; Path=" + path + "; Max-Age=" + strconv.Itoa(maxAge)
+	if maxAge == 0 {
+		maxAge = -1
+	}
+
+	return r.AddCookie(Cookie{
+		Name:   key,
+		Value:  value,
+		Path:   path,
+		MaxAge: maxAge,
+	})
+}
+
+// add a cookie, a cookie with the same name, path and domain will be replaced
+func (r *Response) AddCookie(cookie Cookie) *Response {
+	for i, c := range r.Cookies {
+		if c.Name == cookie.Name && c.Path == cookie.Path && c.Domain == cookie.Domain {
+			r.Cookies[i] = cookie
+			return r
+		}
+	}
+
+	r.Cookies = append(r.Cookies, cookie)
 
 	return r
 }
 
 func (r *Response) Write(conn io.Writer) error {
+	return r.write(context.Background(), conn)
+}
+
+func (r *Response) write(ctx context.Context, conn io.Writer) error {
 	if r == nil {
 		r = NewResponse()
 	}
@@ -89,22 +144,42 @@ func (r *Response) Write(conn io.Writer) error {
 		r.Headers = make(map[string]string)
 	}
 
-	// check if header has a content-type
-	if _, ok := r.Headers["Content-Type"]; !ok {
+	// check if code is 0
+	if r.Code == 0 {
+		r.Code = 200
+	}
+
+	// check if header has a content-type, informational response has no body
+	if _, ok := r.Headers["Content-Type"]; !ok && r.Code >= 200 {
 		r.Headers["Content-Type"] = "text/plain"
 	}
 
-	// add content length to Headers
-	r.Headers["Content-Length"] = strconv.Itoa(len(r.Body))
+	// the body is delimited by closing the connection unless the length is set by the handler
+	if r.Stream != nil {
+		if _, ok := r.Headers["Connection"]; !ok {
+			r.Headers["Connection"] = "close"
+		}
 
-	// check if code is 0
-	if r.Code == 0 {
-		r.Code = 200
+		_, err := conn.Write([]byte(
+			"HTTP/1.1 " + strconv.Itoa(r.Code) + "\r\n" +
+				headerString(r.Headers) +
+				cookieString(r.Cookies) +
+				"\r\n",
+		))
+		if err != nil {
+			return err
+		}
+
+		return r.Stream(ctx, conn)
 	}
 
+	// add content length to Headers
+	r.Headers["Content-Length"] = strconv.Itoa(len(r.Body))
+
 	_, err := conn.Write([]byte(
 		"HTTP/1.1 " + strconv.Itoa(r.Code) + "\r\n" +
 			headerString(r.Headers) +
+			cookieString(r.Cookies) +
 			"\r\n" +
 			r.Body,
 	))
@@ -112,6 +187,112 @@ func (r *Response) Write(conn io.Writer) error {
 	return err
 }
 
+// read the status line and headers of a HTTP/1.x response, the body is left in br
+func ReadResponseHead(br *bufio.Reader) (*Response, error) {
+	resp, _, err := readResponseHead(br)
+
+	return resp, err
+}
+
+// read the status line and headers of a HTTP/1.x response, the body is not read.
+// the header keys are canonicalized and Set-Cookie headers are parsed into [Response.Cookies].
+// keepAlive is false if the server will close the connection after the response
+func readResponseHead(br *bufio.Reader) (resp *Response, keepAlive bool, err error) {
+	tp := textproto.NewReader(br)
+
+	line, err := tp.ReadLine()
+	if err != nil {
+		return nil, false, err
+	}
+
+	proto, status, _ := strings.Cut(line, " ")
+	code, _, _ := strings.Cut(status, " ")
+	if !strings.HasPrefix(proto, "HTTP/1.") || len(code) != 3 {
+		return nil, false, errors.New("invalid status line")
+	}
+
+	resp = NewResponse()
+	resp.Code, err = strconv.Atoi(code)
+	if err != nil {
+		return nil, false, errors.New("invalid status code")
+	}
+
+	headers, err := tp.ReadMIMEHeader()
+	if err != nil {
+		return nil, false, err
+	}
+
+	// HTTP/1.0 only keep the connection if it is asked
+	connection := headers.Get("Connection")
+	if proto == "HTTP/1.0" {
+		keepAlive = headerContainsToken(connection, "keep-alive")
+	} else {
+		keepAlive = !headerContainsToken(connection, "close")
+	}
+
+	for key, values := range headers {
+		if key == "Set-Cookie" {
+			for _, value := range values {
+				if cookie, ok := parseSetCookie(value); ok {
+					resp.Cookies = append(resp.Cookies, cookie)
+				}
+			}
+
+			continue
+		}
+
+		resp.Headers[key] = strings.Join(values, ", ")
+	}
+
+	return resp, keepAlive, nil
+}
+
+// reader of the response body after [readResponseHead], it is nil if the response has no body.
+// the body is delimited by chunked encoding, Content-Length or closing the connection
+func responseBodyReader(br *bufio.Reader, resp *Response, method string) (io.Reader, error) {
+	if method == "HEAD" || resp.Code/100 == 1 || resp.Code == 204 || resp.Code == 304 {
+		return nil, nil
+	}
+
+	if strings.Contains(strings.ToLower(resp.Headers["Transfer-Encoding"]), "chunked") {
+		return &chunkedReader{r: httputil.NewChunkedReader(br), br: br}, nil
+	}
+
+	if value, ok := resp.Headers["Content-Length"]; ok {
+		length, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || length < 0 {
+			return nil, errors.New("invalid content length")
+		}
+
+		return io.LimitReader(br, length), nil
+	}
+
+	return br, nil
+}
+
+// read the trailer after the last chunk, so the connection can be reused
+type chunkedReader struct {
+	r    io.Reader
+	br   *bufio.Reader
+	done bool
+}
+
+func (r *chunkedReader) Read(p []byte) (int, error) {
+	if r.done {
+		return 0, io.EOF
+	}
+
+	n, err := r.r.Read(p)
+	if err == io.EOF {
+		r.done = true
+		if _, terr := textproto.NewReader(r.br).ReadMIMEHeader(); terr != nil && terr != io.EOF {
+			return n, terr
+		}
+	}
+
+	return n, err
+}
+
 func headerString(headers map[string]string) string {
 	var headerString string
 	for key, value := range headers {
@@ -120,3 +301,12 @@ func headerString(headers map[string]string) string {
 
 	return headerString
 }
+
+func cookieString(cookies []Cookie) string {
+	var cookieString string
+	for _, cookie := range cookies {
+		cookieString += "Set-Cookie: " + cookie.String() + "\r\n"
+	}
+
+	return cookieString
+}
@@ -0,0 +1,133 @@
+package chttp
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+)
+
+// placeholder in the Content-Security-Policy that is replaced with the request nonce
+const CSPNoncePlaceholder = "{nonce}"
+
+type cspNonceKey struct{}
+
+// every field is a header value, empty string will not set the header
+type SecureHeadersOption struct {
+	StrictTransportSecurity   string
+	ContentSecurityPolicy     string
+	ContentTypeOptions        string
+	FrameOptions              string
+	ReferrerPolicy            string
+	PermissionsPolicy         string
+	CrossOriginOpenerPolicy   string
+	CrossOriginEmbedderPolicy string
+}
+
+var DefaultSecureHeadersOption = SecureHeadersOption{
+	StrictTransportSecurity:   "max-age=63072000; includeSubDomains",
+	ContentSecurityPolicy:     "default-src 'self'; script-src 'self' 'nonce-{nonce}'; style-src 'self' 'nonce-{nonce}'; object-src 'none'; base-uri 'self'; frame-ancestors 'none'",
+	ContentTypeOptions:        "nosniff",
+	FrameOptions:              "DENY",
+	ReferrerPolicy:            "strict-origin-when-cross-origin",
+	PermissionsPolicy:         "camera=(), microphone=(), geolocation=(), payment=()",
+	CrossOriginOpenerPolicy:   "same-origin",
+	CrossOriginEmbedderPolicy: "require-corp",
+}
+
+// set security headers on every response. a header that is already set by the handler
+// is kept, so it can be overridden per route with [SecureHeadersOverride]. use
+// [Context.CSPNonce] for inline script and style when the policy has the nonce placeholder
+func SecureHeadersMiddleware(option *SecureHeadersOption) Middleware {
+	if option == nil {
+		option = &DefaultSecureHeadersOption
+	}
+
+	headers := option.headers()
+	useNonce := strings.Contains(option.ContentSecurityPolicy, CSPNoncePlaceholder)
+
+	return func(next Handler) Handler {
+		return func(c Context) *Response {
+			nonce := ""
+			if useNonce {
+				nonce = base64.StdEncoding.EncodeToString(randomBytes(16))
+				c.Context = context.WithValue(c.Context, cspNonceKey{}, nonce)
+			}
+
+			resp := next(c)
+			if resp == nil {
+				resp = NewResponse()
+			}
+
+			if resp.Headers == nil {
+				resp.Headers = make(map[string]string)
+			}
+
+			for key, value := range headers {
+				existing, ok := resp.Headers[key]
+				if !ok {
+					if value != "" {
+						resp.Headers[key] = strings.ReplaceAll(value, CSPNoncePlaceholder, nonce)
+					}
+
+					continue
+				}
+
+				// empty value from the route means the header is disabled
+				if existing == "" {
+					delete(resp.Headers, key)
+					continue
+				}
+
+				resp.Headers[key] = strings.ReplaceAll(existing, CSPNoncePlaceholder, nonce)
+			}
+
+			return resp
+		}
+	}
+}
+
+// override security headers for a route, empty value will remove the header.
+// it must be used inside [SecureHeadersMiddleware], e.g. on a single route handler
+func SecureHeadersOverride(headers map[string]string) Middleware {
+	return func(next Handler) Handler {
+		return func(c Context) *Response {
+			resp := next(c)
+			if resp == nil {
+				resp = NewResponse()
+			}
+
+			if resp.Headers == nil {
+				resp.Headers = make(map[string]string)
+			}
+
+			for key, value := range headers {
+				if _, ok := resp.Headers[key]; !ok {
+					resp.Headers[key] = value
+				}
+			}
+
+			return resp
+		}
+	}
+}
+
+// get the Content-Security-Policy nonce of the request, it will be empty if the policy doesnt use it
+func (c Context) CSPNonce() string {
+	nonce, _ := c.Value(cspNonceKey{}).(string)
+	return nonce
+}
+
+func (o SecureHeadersOption) headers() map[string]string {
+	headers := map[string]string{
+		"Strict-Transport-Security":    o.StrictTransportSecurity,
+		"Content-Security-Policy":      o.ContentSecurityPolicy,
+		"X-Content-Type-Options":       o.ContentTypeOptions,
+		"X-Frame-Options":              o.FrameOptions,
+		"Referrer-Policy":              o.ReferrerPolicy,
+		"Permissions-Policy":           o.PermissionsPolicy,
+		"Cross-Origin-Opener-Policy":   o.CrossOriginOpenerPolicy,
+		"Cross-Origin-Embedder-Policy": o.CrossOriginEmbedderPolicy,
+	}
+
+	return headers
+}
@@ -0,0 +1,237 @@
+package chttp
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+var ErrServerClosed = errors.New("server closed")
+
+type Server struct {
+	Addr   string
+	Router *Router
+	// every request context will be derived from this context, [context.Background] will be used if nil
+	BaseContext context.Context
+	// record connection and traffic metrics, request metrics is recorded by [Metrics.Middleware]
+	Metrics *Metrics
+
+	// base tls config for [Server.ListenAndServeTLS], [DefaultTLSConfig] will be used if nil
+	TLSConfig *tls.Config
+	// certificates that is selected by SNI and reloaded on change
+	Certificates *CertStore
+	// how often the certificate files are checked for change, default is 1 minute
+	CertificateCheckInterval time.Duration
+	// CA file to verify client certificates (mTLS)
+	ClientCAFile string
+	// client certificate policy when ClientCAFile is set, default is tls.RequireAndVerifyClientCert
+	ClientAuth tls.ClientAuthType
+	// default is 10 seconds
+	TLSHandshakeTimeout time.Duration
+
+	// HTTP/2 settings, [DefaultHTTP2Option] will be used if nil
+	HTTP2 *HTTP2Option
+	// only serve HTTP/1.1, "h2" will not be advertised by ALPN
+	DisableHTTP2 bool
+
+	mu        sync.Mutex
+	ctx       context.Context
+	cancel    context.CancelFunc
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	wg        sync.WaitGroup
+	closed    bool
+}
+
+func NewServer(addr string, router *Router) *Server {
+	return &Server{
+		Addr:   addr,
+		Router: router,
+	}
+}
+
+func (s *Server) init() {
+	if s.ctx != nil {
+		return
+	}
+
+	base := s.BaseContext
+	if base == nil {
+		base = context.Background()
+	}
+
+	s.ctx, s.cancel = context.WithCancel(base)
+	s.listeners = make(map[net.Listener]struct{})
+	s.conns = make(map[net.Conn]struct{})
+}
+
+// listen on the tcp address and serve every connection with the router
+func (s *Server) ListenAndServe() error {
+	addr := s.Addr
+	if addr == "" {
+		addr = ":8080"
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(listener)
+}
+
+// accept connection from the listener, every connection will be served on its own goroutine.
+// it always return a non-nil error, [ErrServerClosed] will be returned after [Server.Shutdown]
+func (s *Server) Serve(listener net.Listener) error {
+	s.mu.Lock()
+	s.init()
+	if s.closed {
+		s.mu.Unlock()
+		listener.Close()
+
+		return ErrServerClosed
+	}
+	s.listeners[listener] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, listener)
+		s.mu.Unlock()
+
+		listener.Close()
+	}()
+
+	var delay time.Duration
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+
+			// retry temporary error with backoff
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				delay = nextDelay(delay)
+				time.Sleep(delay)
+
+				continue
+			}
+
+			return err
+		}
+		delay = 0
+
+		if !s.trackConn(conn) {
+			conn.Close()
+			return ErrServerClosed
+		}
+
+		go s.serveConn(conn)
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer s.wg.Done()
+	defer s.untrackConn(conn)
+
+	ctx := s.ctx
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		var err error
+		ctx, err = s.tlsHandshake(ctx, tlsConn)
+		if err != nil {
+			slog.Debug("TLS handshake error", "err", err)
+			conn.Close()
+
+			return
+		}
+	}
+
+	if s.Metrics != nil {
+		conn = s.Metrics.instrumentConn(conn)
+	}
+	defer conn.Close()
+
+	err := s.serveProtocol(ctx, conn)
+	if err != nil {
+		slog.Debug("Error while serving connection", "err", err)
+	}
+}
+
+// shutdown the server gracefully, it will stop accepting new connection and cancel every request context,
+// then wait for active connections to finish. if ctx is done first, remaining connections will be closed
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.init()
+	s.closed = true
+	for listener := range s.listeners {
+		listener.Close()
+	}
+	s.mu.Unlock()
+
+	s.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		for conn := range s.conns {
+			conn.Close()
+		}
+		s.mu.Unlock()
+
+		return ctx.Err()
+	}
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.closed
+}
+
+func (s *Server) trackConn(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return false
+	}
+
+	s.conns[conn] = struct{}{}
+	s.wg.Add(1)
+
+	return true
+}
+
+func (s *Server) untrackConn(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+}
+
+func nextDelay(delay time.Duration) time.Duration {
+	if delay == 0 {
+		return 5 * time.Millisecond
+	}
+
+	delay *= 2
+	if delay > time.Second {
+		delay = time.Second
+	}
+
+	return delay
+}
@@ -0,0 +1,519 @@
+package chttp
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type sessionKey struct{}
+
+// session data that is saved in the store
+type SessionData struct {
+	Values     map[string]any `json:"values"`
+	CreatedAt  time.Time      `json:"created_at"`
+	LastAccess time.Time      `json:"last_access"`
+}
+
+// session storage, Load must return nil data without error if the session doesnt exist or expired
+type SessionStore interface {
+	Load(ctx context.Context, id string) (*SessionData, error)
+	Save(ctx context.Context, id string, data SessionData, ttl time.Duration) error
+	Delete(ctx context.Context, id string) error
+}
+
+type Session struct {
+	mu        sync.Mutex
+	id        string
+	oldID     string
+	data      SessionData
+	fresh     bool
+	modified  bool
+	destroyed bool
+}
+
+func (s *Session) ID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.id
+}
+
+func (s *Session) Get(key string) any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.data.Values[key]
+}
+
+// get a string value, it will be empty if the value doesnt exist or not a string
+func (s *Session) GetString(key string) string {
+	value, _ := s.Get(key).(string)
+	return value
+}
+
+// set a value, the value must be encodable to json if the store is [FileSessionStore]
+func (s *Session) Set(key string, value any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data.Values[key] = value
+	s.modified = true
+}
+
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.data.Values, key)
+	s.modified = true
+}
+
+func (s *Session) CreatedAt() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.data.CreatedAt
+}
+
+// rotate the session id and keep the values, call it after login to prevent session fixation
+func (s *Session) Renew() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.oldID == "" {
+		s.oldID = s.id
+	}
+	s.id = newSessionID()
+	s.modified = true
+}
+
+// remove the session from the store and expire the cookie
+func (s *Session) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data.Values = make(map[string]any)
+	s.destroyed = true
+}
+
+// get the session of the request, it will be nil if [SessionManager.Middleware] is not used
+func (c Context) Session() *Session {
+	session, _ := c.Value(sessionKey{}).(*Session)
+	return session
+}
+
+type SessionOption struct {
+	CookieName string
+	Path       string
+	Domain     string
+	Secure     bool
+	HttpOnly   bool
+	SameSite   SameSite
+	// session expire if there is no request within the timeout
+	IdleTimeout time.Duration
+	// session expire after the timeout since it is created, even if it is still active
+	AbsoluteTimeout time.Duration
+	// a new [MemorySessionStore] will be used if nil
+	Store SessionStore
+}
+
+var DefaultSessionOption = SessionOption{
+	CookieName:      "session_id",
+	Path:            "/",
+	HttpOnly:        true,
+	SameSite:        SameSiteLax,
+	IdleTimeout:     30 * time.Minute,
+	AbsoluteTimeout: 24 * time.Hour,
+}
+
+type SessionManager struct {
+	option SessionOption
+}
+
+func NewSessionManager(option *SessionOption) *SessionManager {
+	if option == nil {
+		option = &DefaultSessionOption
+	}
+
+	o := *option
+	if o.CookieName == "" {
+		o.CookieName = DefaultSessionOption.CookieName
+	}
+
+	if o.Path == "" {
+		o.Path = "/"
+	}
+
+	if o.IdleTimeout <= 0 {
+		o.IdleTimeout = DefaultSessionOption.IdleTimeout
+	}
+
+	if o.AbsoluteTimeout <= 0 {
+		o.AbsoluteTimeout = DefaultSessionOption.AbsoluteTimeout
+	}
+
+	if o.Store == nil {
+		o.Store = NewMemorySessionStore()
+	}
+
+	return &SessionManager{option: o}
+}
+
+// load the session before the handler and save it after the handler.
+// a new session is only saved and sent to the client when it is modified
+func (m *SessionManager) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return func(c Context) *Response {
+			session := m.load(c)
+			c.Context = context.WithValue(c.Context, sessionKey{}, session)
+
+			resp := next(c)
+			if resp == nil {
+				resp = NewResponse()
+			}
+
+			if err := m.save(c, session, resp); err != nil {
+				slog.ErrorContext(c, "Error saving session", "err", err)
+			}
+
+			return resp
+		}
+	}
+}
+
+func (m *SessionManager) load(c Context) *Session {
+	now := time.Now()
+
+	id := ""
+	if c.Request.Cookie != nil {
+		id = c.Request.Cookie[m.option.CookieName]
+	}
+
+	if id != "" {
+		data, err := m.option.Store.Load(c, id)
+		if err != nil {
+			slog.ErrorContext(c, "Error loading session", "err", err)
+		}
+
+		if data != nil && !m.expired(data, now) {
+			if data.Values == nil {
+				data.Values = make(map[string]any)
+			}
+
+			return &Session{id: id, data: *data}
+		}
+
+		if data != nil {
+			m.option.Store.Delete(c, id)
+		}
+	}
+
+	return &Session{
+		fresh: true,
+		data: SessionData{
+			Values:    make(map[string]any),
+			CreatedAt: now,
+		},
+	}
+}
+
+func (m *SessionManager) expired(data *SessionData, now time.Time) bool {
+	return now.Sub(data.LastAccess) > m.option.IdleTimeout || now.Sub(data.CreatedAt) > m.option.AbsoluteTimeout
+}
+
+func (m *SessionManager) save(c Context, session *Session, resp *Response) error {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if session.destroyed {
+		if session.oldID != "" {
+			m.option.Store.Delete(c, session.oldID)
+		}
+
+		if session.fresh {
+			return nil
+		}
+
+		resp.AddCookie(m.cookie("", -1))
+
+		return m.option.Store.Delete(c, session.id)
+	}
+
+	// dont create a session for request that doesnt use it
+	if session.fresh && !session.modified {
+		return nil
+	}
+
+	isNew := session.fresh || session.oldID != ""
+	if session.id == "" {
+		session.id = newSessionID()
+	}
+
+	if session.oldID != "" {
+		if err := m.option.Store.Delete(c, session.oldID); err != nil {
+			return err
+		}
+		session.oldID = ""
+	}
+
+	now := time.Now()
+	session.data.LastAccess = now
+
+	// keep the session until idle or absolute timeout, whichever come first
+	ttl := m.option.IdleTimeout
+	remaining := m.option.AbsoluteTimeout - now.Sub(session.data.CreatedAt)
+	if remaining < ttl {
+		ttl = remaining
+	}
+
+	if err := m.option.Store.Save(c, session.id, session.data, ttl); err != nil {
+		return err
+	}
+	session.fresh = false
+
+	if isNew {
+		resp.AddCookie(m.cookie(session.id, int(remaining.Seconds())))
+	}
+
+	return nil
+}
+
+func (m *SessionManager) cookie(value string, maxAge int) Cookie {
+	return Cookie{
+		Name:     m.option.CookieName,
+		Value:    value,
+		Path:     m.option.Path,
+		Domain:   m.option.Domain,
+		MaxAge:   maxAge,
+		Secure:   m.option.Secure,
+		HttpOnly: m.option.HttpOnly,
+		SameSite: m.option.SameSite,
+	}
+}
+
+func newSessionID() string {
+	return base64.RawURLEncoding.EncodeToString(randomBytes(32))
+}
+
+// in memory store, expired sessions are removed periodically
+type MemorySessionStore struct {
+	mu        sync.Mutex
+	sessions  map[string]memorySession
+	lastSweep time.Time
+	// how often expired sessions are removed, default is 1 minute
+	SweepInterval time.Duration
+}
+
+type memorySession struct {
+	data   SessionData
+	expire time.Time
+}
+
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{
+		sessions:      make(map[string]memorySession),
+		SweepInterval: time.Minute,
+	}
+}
+
+func (s *MemorySessionStore) Load(ctx context.Context, id string) (*SessionData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, nil
+	}
+
+	if time.Now().After(session.expire) {
+		delete(s.sessions, id)
+		return nil, nil
+	}
+
+	data := session.data
+	data.Values = copyValues(session.data.Values)
+
+	return &data, nil
+}
+
+func (s *MemorySessionStore) Save(ctx context.Context, id string, data SessionData, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+
+	data.Values = copyValues(data.Values)
+	s.sessions[id] = memorySession{data: data, expire: now.Add(ttl)}
+
+	return nil
+}
+
+func (s *MemorySessionStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	delete(s.sessions, id)
+	s.mu.Unlock()
+
+	return nil
+}
+
+// caller must hold the lock
+func (s *MemorySessionStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < s.SweepInterval {
+		return
+	}
+	s.lastSweep = now
+
+	for id, session := range s.sessions {
+		if now.After(session.expire) {
+			delete(s.sessions, id)
+		}
+	}
+}
+
+func copyValues(values map[string]any) map[string]any {
+	result := make(map[string]any, len(values))
+	for key, value := range values {
+		result[key] = value
+	}
+
+	return result
+}
+
+// store every session as a json file in the directory, the values are decoded
+// as json types (e.g. numbers become float64)
+type FileSessionStore struct {
+	Dir string
+	// how often expired session files are removed, default is 10 minutes
+	SweepInterval time.Duration
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+type fileSession struct {
+	SessionData
+	Expire time.Time `json:"expire"`
+}
+
+func NewFileSessionStore(dir string) (*FileSessionStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	return &FileSessionStore{
+		Dir:           dir,
+		SweepInterval: 10 * time.Minute,
+	}, nil
+}
+
+// the file name is the hash of the id, so the id is never used as a path
+func (s *FileSessionStore) path(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return filepath.Join(s.Dir, hex.EncodeToString(sum[:])+".json")
+}
+
+func (s *FileSessionStore) Load(ctx context.Context, id string) (*SessionData, error) {
+	b, err := os.ReadFile(s.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var session fileSession
+	if err := json.Unmarshal(b, &session); err != nil {
+		return nil, err
+	}
+
+	if time.Now().After(session.Expire) {
+		os.Remove(s.path(id))
+		return nil, nil
+	}
+
+	return &session.SessionData, nil
+}
+
+func (s *FileSessionStore) Save(ctx context.Context, id string, data SessionData, ttl time.Duration) error {
+	s.sweep()
+
+	b, err := json.Marshal(fileSession{SessionData: data, Expire: time.Now().Add(ttl)})
+	if err != nil {
+		return err
+	}
+
+	// write to a temporary file first so a concurrent Load never read a partial file
+	tmp, err := os.CreateTemp(s.Dir, ".session-*")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path(id))
+}
+
+func (s *FileSessionStore) Delete(ctx context.Context, id string) error {
+	err := os.Remove(s.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+// remove expired session files at most once per interval
+func (s *FileSessionStore) sweep() {
+	s.mu.Lock()
+	now := time.Now()
+	if now.Sub(s.lastSweep) < s.SweepInterval {
+		s.mu.Unlock()
+		return
+	}
+	s.lastSweep = now
+	s.mu.Unlock()
+
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+
+		path := filepath.Join(s.Dir, entry.Name())
+		b, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+
+		var session fileSession
+		if json.Unmarshal(b, &session) != nil || now.After(session.Expire) {
+			os.Remove(path)
+		}
+	}
+}
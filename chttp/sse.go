@@ -0,0 +1,203 @@
+package chttp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errSSEClosed = errors.New("event stream is closed")
+
+type SSEOption struct {
+	// comment that is sent periodically to keep the connection open through proxies, zero disable it
+	HeartbeatInterval time.Duration
+	// reconnection time that is sent to the client before the first event, zero will not send it
+	Retry time.Duration
+}
+
+var DefaultSSEOption = SSEOption{
+	HeartbeatInterval: 15 * time.Second,
+}
+
+// a single server-sent event, empty field is not sent
+type Event struct {
+	ID    string
+	Event string
+	// multi-line data is sent as multiple data fields
+	Data  string
+	Retry time.Duration
+}
+
+// stream of server-sent events, it is safe to send from multiple goroutines
+type EventStream struct {
+	ctx         context.Context
+	cancel      context.CancelFunc
+	lastEventID string
+
+	mu     sync.Mutex
+	w      io.Writer
+	err    error
+	closed bool
+}
+
+// respond with text/event-stream, fn is called after the response headers are sent and the
+// stream is closed when it returns. the stream context is cancelled when the client disconnect
+func (c Context) SSE(option *SSEOption, fn func(stream *EventStream) error) *Response {
+	if option == nil {
+		option = &DefaultSSEOption
+	}
+
+	lastEventID := c.Request.GetHeader("Last-Event-ID")
+
+	resp := NewStreamResponse("text/event-stream", func(ctx context.Context, w io.Writer) error {
+		// keep the request values, but only stop when the connection is done
+		streamCtx, cancel := context.WithCancel(context.WithoutCancel(c.Context))
+		defer cancel()
+
+		stop := context.AfterFunc(ctx, cancel)
+		defer stop()
+
+		stream := &EventStream{
+			ctx:         streamCtx,
+			cancel:      cancel,
+			lastEventID: lastEventID,
+			w:           w,
+		}
+
+		if option.Retry > 0 {
+			if err := stream.write("retry: " + strconv.FormatInt(option.Retry.Milliseconds(), 10) + "\n\n"); err != nil {
+				return err
+			}
+		}
+
+		if option.HeartbeatInterval > 0 {
+			done := make(chan struct{})
+			defer close(done)
+
+			go stream.heartbeat(option.HeartbeatInterval, done)
+		}
+
+		err := fn(stream)
+
+		// no more write after the stream is returned, including the heartbeat
+		stream.mu.Lock()
+		defer stream.mu.Unlock()
+
+		stream.closed = true
+		if err != nil {
+			return err
+		}
+
+		return stream.err
+	})
+
+	resp.Headers["Cache-Control"] = "no-cache"
+	// disable response buffering of nginx
+	resp.Headers["X-Accel-Buffering"] = "no"
+
+	return resp
+}
+
+// context that is cancelled when the client disconnect or the stream failed to write
+func (s *EventStream) Context() context.Context {
+	return s.ctx
+}
+
+// closed when the client disconnect
+func (s *EventStream) Done() <-chan struct{} {
+	return s.ctx.Done()
+}
+
+// id of the last event that the client received before reconnecting, send the events
+// after it to resume the stream. it will be empty on the first connection
+func (s *EventStream) LastEventID() string {
+	return s.lastEventID
+}
+
+func (s *EventStream) Send(event Event) error {
+	var b strings.Builder
+
+	if event.ID != "" {
+		b.WriteString("id: " + sseField(event.ID) + "\n")
+	}
+
+	if event.Event != "" {
+		b.WriteString("event: " + sseField(event.Event) + "\n")
+	}
+
+	if event.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(event.Retry.Milliseconds(), 10) + "\n")
+	}
+
+	data := strings.ReplaceAll(event.Data, "\r\n", "\n")
+	data = strings.ReplaceAll(data, "\r", "\n")
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+
+	return s.write(b.String())
+}
+
+// send an event with only data
+func (s *EventStream) SendData(data string) error {
+	return s.Send(Event{Data: data})
+}
+
+// send a comment, it is ignored by the client
+func (s *EventStream) Comment(text string) error {
+	return s.write(": " + sseField(text) + "\n\n")
+}
+
+func (s *EventStream) write(message string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+
+	if s.closed {
+		return errSSEClosed
+	}
+
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(s.w, message); err != nil {
+		s.err = err
+		s.cancel()
+
+		return err
+	}
+
+	return nil
+}
+
+func (s *EventStream) heartbeat(interval time.Duration, done chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			if s.write(":\n\n") != nil {
+				return
+			}
+		}
+	}
+}
+
+// id and event name must be a single line
+func sseField(value string) string {
+	return strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ", "\x00", "").Replace(value)
+}
@@ -0,0 +1,50 @@
+package chttp
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// cancel the request context after the timeout, if the handler is still running when
+// the context is done, it will respond with 504 Gateway Timeout for elapsed deadline
+// or 503 Service Unavailable when the request is cancelled (e.g. server shutdown).
+// zero timeout will only respect the existing deadline, such as the route timeout
+func TimeoutMiddleware(timeout time.Duration) Middleware {
+	return func(next Handler) Handler {
+		return func(c Context) *Response {
+			if timeout > 0 {
+				var cancel context.CancelFunc
+				c.Context, cancel = context.WithTimeout(c.Context, timeout)
+				defer cancel()
+			}
+
+			done := make(chan *Response, 1)
+			panicked := make(chan any, 1)
+
+			go func() {
+				defer func() {
+					if rc := recover(); rc != nil {
+						panicked <- rc
+					}
+				}()
+
+				done <- next(c)
+			}()
+
+			select {
+			case resp := <-done:
+				return resp
+			case rc := <-panicked:
+				// let the router handle the panic
+				panic(rc)
+			case <-c.Done():
+				if errors.Is(c.Err(), context.DeadlineExceeded) {
+					return NewTextResponse("504 Gateway Timeout").SetCode(504)
+				}
+
+				return NewTextResponse("503 Service Unavailable").SetCode(503)
+			}
+		}
+	}
+}
@@ -0,0 +1,360 @@
+package chttp
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+type tlsStateKey struct{}
+
+// modern tls config, TLS 1.2 with AEAD cipher suites and TLS 1.3
+func DefaultTLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:       tls.VersionTLS12,
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
+		NextProtos: []string{"http/1.1"},
+	}
+}
+
+// listen on the tcp address and serve every connection over tls.
+// certFile and keyFile are added to [Server.Certificates], they can be empty
+// if the certificates are already added or [Server.TLSConfig] has its own certificates
+func (s *Server) ListenAndServeTLS(certFile, keyFile string) error {
+	addr := s.Addr
+	if addr == "" {
+		addr = ":8443"
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return s.ServeTLS(listener, certFile, keyFile)
+}
+
+// accept connection from the listener and serve it over tls, see [Server.ListenAndServeTLS]
+func (s *Server) ServeTLS(listener net.Listener, certFile, keyFile string) error {
+	config, err := s.tlsConfig(certFile, keyFile)
+	if err != nil {
+		listener.Close()
+		return err
+	}
+
+	s.mu.Lock()
+	s.init()
+	ctx := s.ctx
+	s.mu.Unlock()
+
+	// reload certificates on file change or SIGHUP until the server is shutdown
+	if s.Certificates != nil {
+		go s.Certificates.Watch(ctx, s.CertificateCheckInterval)
+	}
+
+	return s.Serve(tls.NewListener(listener, config))
+}
+
+func (s *Server) tlsConfig(certFile, keyFile string) (*tls.Config, error) {
+	var config *tls.Config
+	if s.TLSConfig != nil {
+		config = s.TLSConfig.Clone()
+	} else {
+		config = DefaultTLSConfig()
+	}
+
+	if certFile != "" || keyFile != "" {
+		if s.Certificates == nil {
+			s.Certificates = NewCertStore()
+		}
+
+		if err := s.Certificates.Add(certFile, keyFile); err != nil {
+			return nil, err
+		}
+	}
+
+	// prefer HTTP/2 when the client support it
+	if !s.DisableHTTP2 && !slices.Contains(config.NextProtos, "h2") {
+		if len(config.NextProtos) == 0 {
+			config.NextProtos = []string{"http/1.1"}
+		}
+		config.NextProtos = append([]string{"h2"}, config.NextProtos...)
+	}
+
+	if s.Certificates != nil && config.GetCertificate == nil {
+		config.GetCertificate = s.Certificates.GetCertificate
+	}
+
+	if config.GetCertificate == nil && len(config.Certificates) == 0 {
+		return nil, errors.New("tls certificate is required")
+	}
+
+	if s.ClientCAFile != "" {
+		pool, err := LoadCertPool(s.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		config.ClientCAs = pool
+		config.ClientAuth = s.ClientAuth
+		if config.ClientAuth == tls.NoClientCert {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	return config, nil
+}
+
+// complete the handshake and keep the connection state on the context
+func (s *Server) tlsHandshake(ctx context.Context, conn *tls.Conn) (context.Context, error) {
+	timeout := s.TLSHandshakeTimeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	handshakeCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if err := conn.HandshakeContext(handshakeCtx); err != nil {
+		return ctx, err
+	}
+
+	state := conn.ConnectionState()
+
+	return context.WithValue(ctx, tlsStateKey{}, &state), nil
+}
+
+// load PEM certificates into a pool, e.g. CA for client certificate verification
+func LoadCertPool(files ...string) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+
+	for _, file := range files {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, errors.New("no certificate found in " + file)
+		}
+	}
+
+	return pool, nil
+}
+
+// get the tls connection state, it will be nil if the request is not over tls
+func (c Context) TLS() *tls.ConnectionState {
+	state, _ := c.Value(tlsStateKey{}).(*tls.ConnectionState)
+	return state
+}
+
+// get the verified client certificate, it will be nil if the client doesnt send a certificate
+func (c Context) PeerCertificate() *x509.Certificate {
+	state := c.TLS()
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return nil
+	}
+
+	return state.PeerCertificates[0]
+}
+
+// certificates that is selected by SNI, the files are reloaded when they are modified
+type CertStore struct {
+	mu      sync.RWMutex
+	entries []*certEntry
+	names   map[string]*tls.Certificate
+}
+
+type certEntry struct {
+	certFile string
+	keyFile  string
+	cert     *tls.Certificate
+	modTime  time.Time
+}
+
+func NewCertStore() *CertStore {
+	return &CertStore{
+		names: make(map[string]*tls.Certificate),
+	}
+}
+
+// load a certificate and key pair, the names are taken from the certificate SAN
+func (s *CertStore) Add(certFile, keyFile string) error {
+	entry := &certEntry{certFile: certFile, keyFile: keyFile}
+	if err := entry.load(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries = append(s.entries, entry)
+	s.index()
+
+	return nil
+}
+
+// load every certificate again, the previous certificate is kept if it cannot be loaded
+func (s *CertStore) Reload() error {
+	s.mu.RLock()
+	entries := append([]*certEntry(nil), s.entries...)
+	s.mu.RUnlock()
+
+	var errs []error
+	loaded := make([]certEntry, len(entries))
+	for i, entry := range entries {
+		loaded[i] = certEntry{certFile: entry.certFile, keyFile: entry.keyFile}
+		if err := loaded[i].load(); err != nil {
+			errs = append(errs, err)
+			loaded[i] = *entry
+		}
+	}
+
+	s.mu.Lock()
+	for i, entry := range entries {
+		*entry = loaded[i]
+	}
+	s.index()
+	s.mu.Unlock()
+
+	return errors.Join(errs...)
+}
+
+// reload certificates when the files are modified or SIGHUP is received, it block until ctx is done
+func (s *CertStore) Watch(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			if err := s.Reload(); err != nil {
+				slog.Error("Error reloading certificate", "err", err)
+			}
+		case <-ticker.C:
+			if s.modified() {
+				if err := s.Reload(); err != nil {
+					slog.Error("Error reloading certificate", "err", err)
+				}
+			}
+		}
+	}
+}
+
+func (s *CertStore) modified() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, entry := range s.entries {
+		if latestModTime(entry.certFile, entry.keyFile).After(entry.modTime) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// select certificate by SNI, exact name first then wildcard, the first certificate is the default
+func (s *CertStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(s.entries) == 0 {
+		return nil, errors.New("no certificate")
+	}
+
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if cert, ok := s.names[name]; ok {
+		return cert, nil
+	}
+
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		if cert, ok := s.names["*"+name[i:]]; ok {
+			return cert, nil
+		}
+	}
+
+	return s.entries[0].cert, nil
+}
+
+// caller must hold the lock
+func (s *CertStore) index() {
+	s.names = make(map[string]*tls.Certificate)
+
+	// earlier certificate win if the names overlap
+	for i := len(s.entries) - 1; i >= 0; i-- {
+		cert := s.entries[i].cert
+		if cert.Leaf == nil {
+			continue
+		}
+
+		if cert.Leaf.Subject.CommonName != "" && len(cert.Leaf.DNSNames) == 0 {
+			s.names[strings.ToLower(cert.Leaf.Subject.CommonName)] = cert
+		}
+
+		for _, name := range cert.Leaf.DNSNames {
+			s.names[strings.ToLower(name)] = cert
+		}
+	}
+}
+
+func (e *certEntry) load() error {
+	modTime := latestModTime(e.certFile, e.keyFile)
+
+	cert, err := tls.LoadX509KeyPair(e.certFile, e.keyFile)
+	if err != nil {
+		return err
+	}
+
+	if cert.Leaf == nil {
+		cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return err
+		}
+	}
+
+	e.cert = &cert
+	e.modTime = modTime
+
+	return nil
+}
+
+func latestModTime(files ...string) time.Time {
+	var latest time.Time
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest
+}
@@ -0,0 +1,221 @@
+package chttp
+
+import (
+	"context"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+type SpanStatus int
+
+const (
+	SpanStatusUnset SpanStatus = iota
+	SpanStatusOK
+	SpanStatusError
+)
+
+// pluggable tracer, it can be implemented with an OpenTelemetry tracer adapter
+type Tracer interface {
+	// start a span as a child of the trace context in ctx, a new trace is started if ctx doesnt have one.
+	// the returned context must carry the new span trace context, see [WithTraceContext]
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+type Span interface {
+	SpanContext() TraceContext
+	SetAttribute(key string, value any)
+	SetStatus(status SpanStatus, description string)
+	RecordError(err error)
+	End()
+}
+
+// finished span data that is passed to the exporter
+type SpanData struct {
+	Name              string
+	TraceID           string
+	SpanID            string
+	ParentSpanID      string
+	Start             time.Time
+	End               time.Time
+	Attributes        map[string]any
+	Status            SpanStatus
+	StatusDescription string
+	Errors            []error
+}
+
+type SpanExporter interface {
+	ExportSpan(span SpanData)
+}
+
+// simple tracer that send every finished span to the exporter
+type SimpleTracer struct {
+	Exporter SpanExporter
+}
+
+func NewTracer(exporter SpanExporter) *SimpleTracer {
+	return &SimpleTracer{Exporter: exporter}
+}
+
+func (t *SimpleTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	span := &simpleSpan{
+		tracer: t,
+		data: SpanData{
+			Name:       name,
+			Start:      time.Now(),
+			Attributes: make(map[string]any),
+		},
+	}
+
+	parent, ok := TraceContextFromContext(ctx)
+	if ok && parent.IsValid() {
+		span.trace = TraceContext{
+			TraceID:      parent.TraceID,
+			SpanID:       hex.EncodeToString(randomBytes(8)),
+			ParentSpanID: parent.SpanID,
+			Flags:        parent.Flags,
+			TraceState:   parent.TraceState,
+		}
+		span.data.ParentSpanID = parent.SpanID
+	} else {
+		span.trace = NewTraceContext()
+	}
+
+	span.data.TraceID = span.trace.TraceID
+	span.data.SpanID = span.trace.SpanID
+
+	return WithTraceContext(ctx, span.trace), span
+}
+
+type simpleSpan struct {
+	tracer *SimpleTracer
+	trace  TraceContext
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+func (s *simpleSpan) SpanContext() TraceContext {
+	return s.trace
+}
+
+func (s *simpleSpan) SetAttribute(key string, value any) {
+	s.mu.Lock()
+	s.data.Attributes[key] = value
+	s.mu.Unlock()
+}
+
+func (s *simpleSpan) SetStatus(status SpanStatus, description string) {
+	s.mu.Lock()
+	s.data.Status = status
+	s.data.StatusDescription = description
+	s.mu.Unlock()
+}
+
+func (s *simpleSpan) RecordError(err error) {
+	if err == nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.data.Errors = append(s.data.Errors, err)
+	s.mu.Unlock()
+}
+
+// end the span and export it, calling End more than once has no effect
+func (s *simpleSpan) End() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+
+	data := s.data
+	data.Attributes = make(map[string]any, len(s.data.Attributes))
+	for key, value := range s.data.Attributes {
+		data.Attributes[key] = value
+	}
+	data.Errors = append([]error(nil), s.data.Errors...)
+	s.mu.Unlock()
+
+	if s.tracer.Exporter != nil {
+		s.tracer.Exporter.ExportSpan(data)
+	}
+}
+
+// exporter that keep finished spans in memory, useful to assert spans in tests
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func NewInMemoryExporter() *InMemoryExporter {
+	return &InMemoryExporter{}
+}
+
+func (e *InMemoryExporter) ExportSpan(span SpanData) {
+	e.mu.Lock()
+	e.spans = append(e.spans, span)
+	e.mu.Unlock()
+}
+
+// get a copy of every finished span in the order they are ended
+func (e *InMemoryExporter) Spans() []SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return append([]SpanData(nil), e.spans...)
+}
+
+func (e *InMemoryExporter) Reset() {
+	e.mu.Lock()
+	e.spans = nil
+	e.mu.Unlock()
+}
+
+// start a server span for the request, the parent is taken from the traceparent header
+func startRequestSpan(tracer Tracer, c Context) (context.Context, Span) {
+	ctx := c.Context
+	if _, ok := TraceContextFromContext(ctx); !ok {
+		if parent, err := ParseTraceparent(c.Request.GetHeader(HeaderTraceparent)); err == nil {
+			parent.TraceState = c.Request.GetHeader(HeaderTracestate)
+			ctx = WithTraceContext(ctx, parent)
+		}
+	}
+
+	name := c.Request.Method
+	if c.Pattern != "" {
+		name += " " + c.Pattern
+	}
+
+	ctx, span := tracer.Start(ctx, name)
+	span.SetAttribute("http.request.method", c.Request.Method)
+	span.SetAttribute("url.path", c.Request.Path)
+	if c.Pattern != "" {
+		span.SetAttribute("http.route", c.Pattern)
+	}
+
+	return ctx, span
+}
+
+// record the response status and the error that is passed to the error handler, then end the span
+func endRequestSpan(span Span, resp *Response, err error) {
+	code := 200
+	if resp != nil && resp.Code != 0 {
+		code = resp.Code
+	}
+
+	span.SetAttribute("http.response.status_code", code)
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(SpanStatusError, err.Error())
+	} else if code >= 500 {
+		span.SetStatus(SpanStatusError, "")
+	}
+
+	span.End()
+}
@@ -0,0 +1,331 @@
+package cjwt
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+)
+
+// json web key (RFC 7517)
+type jwk struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	Curve     string `json:"crv"`
+
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	P string `json:"p"`
+	Q string `json:"q"`
+
+	// EC and OKP
+	X string `json:"x"`
+	Y string `json:"y"`
+
+	// private part of RSA, EC and OKP
+	D string `json:"d"`
+
+	// symmetric
+	K string `json:"k"`
+}
+
+// parse a json web key set, keys with unsupported type or "use" other than "sig" are skipped
+func ParseJWKS(data []byte) ([]Key, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make([]Key, 0, len(set.Keys))
+	for _, raw := range set.Keys {
+		if raw.Use != "" && raw.Use != "sig" {
+			continue
+		}
+
+		key, err := raw.key()
+		if err != nil {
+			return nil, err
+		}
+
+		if key.Key == nil {
+			continue
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+func (j jwk) key() (Key, error) {
+	key := Key{ID: j.KeyID, Algorithm: j.Algorithm}
+
+	var err error
+	switch j.KeyType {
+	case "oct":
+		key.Key, err = decodeSegment(j.K)
+	case "RSA":
+		key.Key, err = j.rsaKey()
+	case "EC":
+		if j.Curve != "P-256" {
+			return Key{}, nil
+		}
+		key.Key, err = j.ecKey()
+	case "OKP":
+		if j.Curve != "Ed25519" {
+			return Key{}, nil
+		}
+		key.Key, err = j.edKey()
+	default:
+		return Key{}, nil
+	}
+
+	if err != nil {
+		return Key{}, errors.New("invalid jwk " + j.KeyID + ": " + err.Error())
+	}
+
+	if key.Algorithm == "" {
+		key.Algorithm = inferAlgorithm(key.Key)
+	}
+
+	return key, nil
+}
+
+func (j jwk) rsaKey() (any, error) {
+	n, err := decodeBigInt(j.N)
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := decodeBigInt(j.E)
+	if err != nil {
+		return nil, err
+	}
+
+	if !e.IsInt64() || e.Int64() > 1<<31-1 {
+		return nil, errors.New("invalid rsa exponent")
+	}
+
+	public := rsa.PublicKey{N: n, E: int(e.Int64())}
+	if j.D == "" {
+		return &public, nil
+	}
+
+	d, err := decodeBigInt(j.D)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := decodeBigInt(j.P)
+	if err != nil {
+		return nil, err
+	}
+
+	q, err := decodeBigInt(j.Q)
+	if err != nil {
+		return nil, err
+	}
+
+	private := &rsa.PrivateKey{PublicKey: public, D: d, Primes: []*big.Int{p, q}}
+	if err := private.Validate(); err != nil {
+		return nil, err
+	}
+	private.Precompute()
+
+	return private, nil
+}
+
+func (j jwk) ecKey() (any, error) {
+	x, err := decodeSegment(j.X)
+	if err != nil {
+		return nil, err
+	}
+
+	y, err := decodeSegment(j.Y)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(x) != 32 || len(y) != 32 {
+		return nil, errors.New("invalid P-256 coordinate")
+	}
+
+	// validate the point is on the curve
+	point := append([]byte{0x04}, append(x, y...)...)
+	if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+		return nil, err
+	}
+
+	public := ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(x),
+		Y:     new(big.Int).SetBytes(y),
+	}
+
+	if j.D == "" {
+		return &public, nil
+	}
+
+	d, err := decodeSegment(j.D)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ecdsa.PrivateKey{PublicKey: public, D: new(big.Int).SetBytes(d)}, nil
+}
+
+func (j jwk) edKey() (any, error) {
+	x, err := decodeSegment(j.X)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(x) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid Ed25519 public key")
+	}
+
+	if j.D == "" {
+		return ed25519.PublicKey(x), nil
+	}
+
+	d, err := decodeSegment(j.D)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(d) != ed25519.SeedSize {
+		return nil, errors.New("invalid Ed25519 private key")
+	}
+
+	return ed25519.NewKeyFromSeed(d), nil
+}
+
+func decodeSegment(s string) ([]byte, error) {
+	if s == "" {
+		return nil, errors.New("missing key parameter")
+	}
+
+	return base64.RawURLEncoding.DecodeString(s)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := decodeSegment(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
+
+// key set that is loaded from a JWKS file. the file is reloaded when it is modified,
+// so keys can be rotated by replacing the file without restarting the service
+type JWKSFile struct {
+	Path string
+	// how often the file modification time is checked, default is 1 minute
+	CheckInterval time.Duration
+
+	mu        sync.RWMutex
+	keys      []Key
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+// load the JWKS file, it will return an error if the file cannot be loaded
+func NewJWKSFile(path string) (*JWKSFile, error) {
+	f := &JWKSFile{
+		Path:          path,
+		CheckInterval: time.Minute,
+	}
+
+	if err := f.Reload(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// load the file again, the previous keys are kept if the file is invalid
+func (f *JWKSFile) Reload() error {
+	info, err := os.Stat(f.Path)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(f.Path)
+	if err != nil {
+		return err
+	}
+
+	keys, err := ParseJWKS(data)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	f.keys = keys
+	f.modTime = info.ModTime()
+	f.lastCheck = time.Now()
+	f.mu.Unlock()
+
+	return nil
+}
+
+func (f *JWKSFile) Lookup(kid, alg string) ([]Key, error) {
+	f.reloadIfModified(false)
+
+	f.mu.RLock()
+	keys := lookupKeys(f.keys, kid, alg)
+	f.mu.RUnlock()
+
+	// unknown kid may be a new key that is just rotated
+	if len(keys) == 0 && kid != "" && f.reloadIfModified(true) {
+		f.mu.RLock()
+		keys = lookupKeys(f.keys, kid, alg)
+		f.mu.RUnlock()
+	}
+
+	return keys, nil
+}
+
+// check the file at most once per interval, or once per second if force is true.
+// return true if the file is reloaded
+func (f *JWKSFile) reloadIfModified(force bool) bool {
+	interval := f.CheckInterval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	if force {
+		interval = time.Second
+	}
+
+	f.mu.Lock()
+	if time.Since(f.lastCheck) < interval {
+		f.mu.Unlock()
+		return false
+	}
+	f.lastCheck = time.Now()
+	modTime := f.modTime
+	f.mu.Unlock()
+
+	info, err := os.Stat(f.Path)
+	if err != nil || info.ModTime().Equal(modTime) {
+		return false
+	}
+
+	return f.Reload() == nil
+}
@@ -0,0 +1,60 @@
+package cjwt
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTestJWKS(t *testing.T, path, kid, secret string, modTime time.Time) {
+	t.Helper()
+
+	data := `{"keys":[{"kty":"oct","kid":"` + kid + `","k":"` + base64.RawURLEncoding.EncodeToString([]byte(secret)) + `"}]}`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// the rotated key is found by reloading the file when the token has an unknown kid
+func TestJWKSFileReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeTestJWKS(t, path, "old", "old secret", time.Now().Add(-time.Hour))
+
+	keys, err := NewJWKSFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verifier := newTestVerifier(VerifyOption{Keys: keys})
+
+	if _, err := verifier.Verify(signTestToken(t, Key{ID: "old", Key: []byte("old secret")}, Claims{})); err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestJWKS(t, path, "new", "new secret", time.Now())
+	token := signTestToken(t, Key{ID: "new", Key: []byte("new secret")}, Claims{})
+
+	// the file is not reloaded more than once per second
+	if _, err := verifier.Verify(token); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("got %v, want %v", err, ErrKeyNotFound)
+	}
+
+	keys.mu.Lock()
+	keys.lastCheck = time.Now().Add(-2 * time.Second)
+	keys.mu.Unlock()
+
+	if _, err := verifier.Verify(token); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := verifier.Verify(signTestToken(t, Key{ID: "old", Key: []byte("old secret")}, Claims{})); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("removed key got %v, want %v", err, ErrKeyNotFound)
+	}
+}
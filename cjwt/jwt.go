@@ -0,0 +1,329 @@
+package cjwt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrTokenMalformed        = errors.New("token is malformed")
+	ErrSignatureInvalid      = errors.New("token signature is invalid")
+	ErrAlgorithmNotAllowed   = errors.New("token algorithm is not allowed")
+	ErrKeyNotFound           = errors.New("token key is not found")
+	ErrTokenExpired          = errors.New("token is expired")
+	ErrTokenNotYetValid      = errors.New("token is not valid yet")
+	ErrTokenUsedBeforeIssued = errors.New("token is used before issued")
+	ErrTokenMissingExpiry    = errors.New("token doesnt have expiration time")
+	ErrInvalidIssuer         = errors.New("token issuer is invalid")
+	ErrInvalidAudience       = errors.New("token audience is invalid")
+	ErrNoClaims              = errors.New("context doesnt have claims")
+)
+
+type Header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ,omitempty"`
+	KeyID     string `json:"kid,omitempty"`
+}
+
+// registered claims (RFC 7519), use [Claims.Decode] to get the custom claims
+type Claims struct {
+	Issuer    string       `json:"iss,omitempty"`
+	Subject   string       `json:"sub,omitempty"`
+	Audience  Audience     `json:"aud,omitempty"`
+	ExpiresAt *NumericDate `json:"exp,omitempty"`
+	NotBefore *NumericDate `json:"nbf,omitempty"`
+	IssuedAt  *NumericDate `json:"iat,omitempty"`
+	ID        string       `json:"jti,omitempty"`
+
+	raw []byte
+}
+
+// decode the whole payload into v, it can be used to read custom claims
+func (c *Claims) Decode(v any) error {
+	if c.raw == nil {
+		return errors.New("claims doesnt have payload")
+	}
+
+	return json.Unmarshal(c.raw, v)
+}
+
+// audience can be a single string or an array of string
+type Audience []string
+
+func (a Audience) Contains(audience string) bool {
+	for _, aud := range a {
+		if aud == audience {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+
+	return json.Marshal([]string(a))
+}
+
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+
+	*a = multiple
+	return nil
+}
+
+// time in seconds since unix epoch
+type NumericDate struct {
+	time.Time
+}
+
+func NewNumericDate(t time.Time) *NumericDate {
+	return &NumericDate{t.Truncate(time.Second)}
+}
+
+func (d NumericDate) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.FormatInt(d.Unix(), 10)), nil
+}
+
+func (d *NumericDate) UnmarshalJSON(data []byte) error {
+	var number json.Number
+	if err := json.Unmarshal(data, &number); err != nil {
+		return err
+	}
+
+	f, err := number.Float64()
+	if err != nil {
+		return err
+	}
+
+	sec, frac := math.Modf(f)
+	d.Time = time.Unix(int64(sec), int64(frac*1e9))
+
+	return nil
+}
+
+// unverified token parts
+type token struct {
+	header       Header
+	claims       Claims
+	signingInput string
+	signature    []byte
+}
+
+func parse(raw string) (*token, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, ErrTokenMalformed
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
+
+	t := &token{
+		signingInput: parts[0] + "." + parts[1],
+		signature:    signature,
+	}
+
+	if err := json.Unmarshal(headerJSON, &t.header); err != nil || t.header.Algorithm == "" {
+		return nil, ErrTokenMalformed
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	if err := decoder.Decode(&t.claims); err != nil {
+		return nil, ErrTokenMalformed
+	}
+	t.claims.raw = payload
+
+	return t, nil
+}
+
+type VerifyOption struct {
+	Keys KeySet
+	// allowed algorithms, every supported algorithm is allowed if empty
+	Algorithms []string
+	// expected issuer, it is not checked if empty
+	Issuer string
+	// expected audience, it is not checked if empty
+	Audience string
+	// clock skew that is tolerated when checking exp, nbf and iat
+	Leeway time.Duration
+	// reject token without exp claim
+	RequireExpiry bool
+	// used to get current time, [time.Now] will be used if nil
+	Now func() time.Time
+}
+
+type Verifier struct {
+	option VerifyOption
+}
+
+func NewVerifier(option VerifyOption) *Verifier {
+	if option.Keys == nil {
+		panic("verifier key set is required")
+	}
+
+	if option.Now == nil {
+		option.Now = time.Now
+	}
+
+	return &Verifier{option: option}
+}
+
+// verify the token signature and claims
+func (v *Verifier) Verify(raw string) (*Claims, error) {
+	t, err := parse(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	alg := t.header.Algorithm
+	if !supportedAlgorithm(alg) || !v.algorithmAllowed(alg) {
+		return nil, ErrAlgorithmNotAllowed
+	}
+
+	keys, err := v.option.Keys.Lookup(t.header.KeyID, alg)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(keys) == 0 {
+		return nil, ErrKeyNotFound
+	}
+
+	verified := false
+	for _, key := range keys {
+		if verifySignature(alg, key, t.signingInput, t.signature) == nil {
+			verified = true
+			break
+		}
+	}
+
+	if !verified {
+		return nil, ErrSignatureInvalid
+	}
+
+	if err := v.validate(&t.claims); err != nil {
+		return nil, err
+	}
+
+	return &t.claims, nil
+}
+
+func (v *Verifier) algorithmAllowed(alg string) bool {
+	if len(v.option.Algorithms) == 0 {
+		return true
+	}
+
+	for _, allowed := range v.option.Algorithms {
+		if allowed == alg {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (v *Verifier) validate(claims *Claims) error {
+	now := v.option.Now()
+	leeway := v.option.Leeway
+
+	if claims.ExpiresAt == nil {
+		if v.option.RequireExpiry {
+			return ErrTokenMissingExpiry
+		}
+	} else if !now.Before(claims.ExpiresAt.Add(leeway)) {
+		return ErrTokenExpired
+	}
+
+	if claims.NotBefore != nil && now.Add(leeway).Before(claims.NotBefore.Time) {
+		return ErrTokenNotYetValid
+	}
+
+	if claims.IssuedAt != nil && now.Add(leeway).Before(claims.IssuedAt.Time) {
+		return ErrTokenUsedBeforeIssued
+	}
+
+	if v.option.Issuer != "" && claims.Issuer != v.option.Issuer {
+		return ErrInvalidIssuer
+	}
+
+	if v.option.Audience != "" && !claims.Audience.Contains(v.option.Audience) {
+		return ErrInvalidAudience
+	}
+
+	return nil
+}
+
+type Signer struct {
+	key Key
+}
+
+// create a signer with a private key, the key algorithm will be used for the token
+func NewSigner(key Key) (*Signer, error) {
+	if key.Algorithm == "" {
+		key.Algorithm = inferAlgorithm(key.Key)
+	}
+
+	if !supportedAlgorithm(key.Algorithm) {
+		return nil, ErrAlgorithmNotAllowed
+	}
+
+	return &Signer{key: key}, nil
+}
+
+// sign the claims, it can be [Claims] or any struct or map that can be encoded to json
+func (s *Signer) Sign(claims any) (string, error) {
+	header, err := json.Marshal(Header{
+		Algorithm: s.key.Algorithm,
+		Type:      "JWT",
+		KeyID:     s.key.ID,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	signature, err := sign(s.key.Algorithm, s.key, signingInput)
+	if err != nil {
+		return "", err
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
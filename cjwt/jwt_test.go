@@ -0,0 +1,225 @@
+package cjwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testNow = time.Unix(1700000000, 0)
+
+func newTestRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+func signTestToken(t *testing.T, key Key, claims any) string {
+	t.Helper()
+
+	signer, err := NewSigner(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := signer.Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
+
+func newTestVerifier(option VerifyOption) *Verifier {
+	option.Now = func() time.Time { return testNow }
+
+	return NewVerifier(option)
+}
+
+func TestVerifyAlgorithms(t *testing.T) {
+	rsaKey := newTestRSAKey(t)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		alg     string
+		private any
+		public  any
+	}{
+		{HS256, []byte("secret"), []byte("secret")},
+		{RS256, rsaKey, &rsaKey.PublicKey},
+		{ES256, ecKey, &ecKey.PublicKey},
+		{EdDSA, edPrivate, edPublic},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.alg, func(t *testing.T) {
+			token := signTestToken(t, Key{ID: "key", Key: tt.private}, Claims{Subject: "user"})
+
+			verifier := newTestVerifier(VerifyOption{Keys: NewKeySet(Key{ID: "key", Key: tt.public})})
+			claims, err := verifier.Verify(token)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if claims.Subject != "user" {
+				t.Fatalf("subject is %q", claims.Subject)
+			}
+
+			// the payload is changed after the token is signed
+			parts := strings.Split(token, ".")
+			tampered := parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"admin"}`)) + "." + parts[2]
+
+			if _, err := verifier.Verify(tampered); !errors.Is(err, ErrSignatureInvalid) {
+				t.Fatalf("got %v, want %v", err, ErrSignatureInvalid)
+			}
+
+			// the algorithm is not allowed by the verifier
+			verifier = newTestVerifier(VerifyOption{Keys: NewKeySet(Key{ID: "key", Key: tt.public}), Algorithms: []string{"none"}})
+			if _, err := verifier.Verify(token); !errors.Is(err, ErrAlgorithmNotAllowed) {
+				t.Fatalf("got %v, want %v", err, ErrAlgorithmNotAllowed)
+			}
+		})
+	}
+}
+
+// HS256 token that is signed with the RSA public key as the secret must not be accepted
+func TestVerifyAlgorithmConfusion(t *testing.T) {
+	rsaKey := newTestRSAKey(t)
+
+	public, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := signTestToken(t, Key{Key: public}, Claims{Subject: "admin"})
+
+	tests := []struct {
+		name string
+		key  Key
+		want error
+	}{
+		{"inferred algorithm", Key{Key: &rsaKey.PublicKey}, ErrKeyNotFound},
+		{"wrong algorithm", Key{Algorithm: HS256, Key: &rsaKey.PublicKey}, ErrSignatureInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verifier := newTestVerifier(VerifyOption{Keys: NewKeySet(tt.key)})
+
+			if _, err := verifier.Verify(token); !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyNone(t *testing.T) {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"admin"}`))
+
+	verifier := newTestVerifier(VerifyOption{Keys: NewKeySet(Key{Key: []byte("secret")})})
+
+	for _, token := range []string{header + "." + payload + ".", header + "." + payload} {
+		if _, err := verifier.Verify(token); err == nil {
+			t.Fatalf("token %q is accepted", token)
+		}
+	}
+
+	if _, err := verifier.Verify(header + "." + payload + "."); !errors.Is(err, ErrAlgorithmNotAllowed) {
+		t.Fatalf("got %v, want %v", err, ErrAlgorithmNotAllowed)
+	}
+}
+
+func TestVerifyTime(t *testing.T) {
+	key := Key{Key: []byte("secret")}
+	date := func(d time.Duration) *NumericDate { return NewNumericDate(testNow.Add(d)) }
+
+	tests := []struct {
+		name   string
+		claims Claims
+		leeway time.Duration
+		want   error
+	}{
+		{"valid", Claims{ExpiresAt: date(time.Minute), NotBefore: date(-time.Minute), IssuedAt: date(-time.Minute)}, 0, nil},
+		{"expired", Claims{ExpiresAt: date(-time.Second)}, 0, ErrTokenExpired},
+		{"expired now", Claims{ExpiresAt: date(0)}, 0, ErrTokenExpired},
+		{"expired within leeway", Claims{ExpiresAt: date(-time.Second)}, time.Minute, nil},
+		{"expired after leeway", Claims{ExpiresAt: date(-2 * time.Minute)}, time.Minute, ErrTokenExpired},
+		{"not before", Claims{NotBefore: date(time.Second)}, 0, ErrTokenNotYetValid},
+		{"not before within leeway", Claims{NotBefore: date(time.Second)}, time.Minute, nil},
+		{"not before after leeway", Claims{NotBefore: date(2 * time.Minute)}, time.Minute, ErrTokenNotYetValid},
+		{"issued in the future", Claims{IssuedAt: date(time.Second)}, 0, ErrTokenUsedBeforeIssued},
+		{"issued within leeway", Claims{IssuedAt: date(time.Second)}, time.Minute, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := signTestToken(t, key, tt.claims)
+			verifier := newTestVerifier(VerifyOption{Keys: NewKeySet(key), Leeway: tt.leeway})
+
+			if _, err := verifier.Verify(token); !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	t.Run("require expiry", func(t *testing.T) {
+		token := signTestToken(t, key, Claims{Subject: "user"})
+		verifier := newTestVerifier(VerifyOption{Keys: NewKeySet(key), RequireExpiry: true})
+
+		if _, err := verifier.Verify(token); !errors.Is(err, ErrTokenMissingExpiry) {
+			t.Fatalf("got %v, want %v", err, ErrTokenMissingExpiry)
+		}
+	})
+}
+
+func TestVerifyIssuerAudience(t *testing.T) {
+	key := Key{Key: []byte("secret")}
+
+	tests := []struct {
+		name   string
+		claims Claims
+		want   error
+	}{
+		{"valid", Claims{Issuer: "issuer", Audience: Audience{"api"}}, nil},
+		{"one of the audience", Claims{Issuer: "issuer", Audience: Audience{"web", "api"}}, nil},
+		{"wrong issuer", Claims{Issuer: "other", Audience: Audience{"api"}}, ErrInvalidIssuer},
+		{"missing issuer", Claims{Audience: Audience{"api"}}, ErrInvalidIssuer},
+		{"wrong audience", Claims{Issuer: "issuer", Audience: Audience{"web"}}, ErrInvalidAudience},
+		{"missing audience", Claims{Issuer: "issuer"}, ErrInvalidAudience},
+	}
+
+	verifier := newTestVerifier(VerifyOption{Keys: NewKeySet(key), Issuer: "issuer", Audience: "api"})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := signTestToken(t, key, tt.claims)
+
+			if _, err := verifier.Verify(token); !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,231 @@
+package cjwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+)
+
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+	EdDSA = "EdDSA"
+)
+
+// signing or verification key, the value of Key depend on the algorithm:
+//   - HS256: []byte
+//   - RS256: *rsa.PublicKey or *rsa.PrivateKey
+//   - ES256: *ecdsa.PublicKey or *ecdsa.PrivateKey on P-256
+//   - EdDSA: ed25519.PublicKey or ed25519.PrivateKey
+type Key struct {
+	ID        string
+	Algorithm string
+	Key       any
+}
+
+// source of verification keys
+type KeySet interface {
+	// get keys for the key id and algorithm, every key with the algorithm is returned if kid is empty
+	Lookup(kid, alg string) ([]Key, error)
+}
+
+// key set that never change
+type StaticKeySet []Key
+
+func NewKeySet(keys ...Key) StaticKeySet {
+	for i := range keys {
+		if keys[i].Algorithm == "" {
+			keys[i].Algorithm = inferAlgorithm(keys[i].Key)
+		}
+	}
+
+	return StaticKeySet(keys)
+}
+
+func (s StaticKeySet) Lookup(kid, alg string) ([]Key, error) {
+	return lookupKeys(s, kid, alg), nil
+}
+
+func lookupKeys(keys []Key, kid, alg string) []Key {
+	var result []Key
+	for _, key := range keys {
+		if key.Algorithm != alg {
+			continue
+		}
+
+		if kid != "" && key.ID != kid {
+			continue
+		}
+
+		result = append(result, key)
+	}
+
+	return result
+}
+
+func supportedAlgorithm(alg string) bool {
+	switch alg {
+	case HS256, RS256, ES256, EdDSA:
+		return true
+	}
+
+	return false
+}
+
+func inferAlgorithm(key any) string {
+	switch k := key.(type) {
+	case []byte:
+		return HS256
+	case *rsa.PublicKey, *rsa.PrivateKey:
+		return RS256
+	case *ecdsa.PublicKey:
+		if k.Curve == elliptic.P256() {
+			return ES256
+		}
+	case *ecdsa.PrivateKey:
+		if k.Curve == elliptic.P256() {
+			return ES256
+		}
+	case ed25519.PublicKey, ed25519.PrivateKey:
+		return EdDSA
+	}
+
+	return ""
+}
+
+// verify signature, the key type must match the algorithm to avoid algorithm confusion
+func verifySignature(alg string, key Key, signingInput string, signature []byte) error {
+	switch alg {
+	case HS256:
+		secret, ok := key.Key.([]byte)
+		if !ok {
+			return ErrKeyNotFound
+		}
+
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(signingInput))
+
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return ErrSignatureInvalid
+		}
+
+		return nil
+	case RS256:
+		var public *rsa.PublicKey
+		switch k := key.Key.(type) {
+		case *rsa.PublicKey:
+			public = k
+		case *rsa.PrivateKey:
+			public = &k.PublicKey
+		default:
+			return ErrKeyNotFound
+		}
+
+		digest := sha256.Sum256([]byte(signingInput))
+		if rsa.VerifyPKCS1v15(public, crypto.SHA256, digest[:], signature) != nil {
+			return ErrSignatureInvalid
+		}
+
+		return nil
+	case ES256:
+		var public *ecdsa.PublicKey
+		switch k := key.Key.(type) {
+		case *ecdsa.PublicKey:
+			public = k
+		case *ecdsa.PrivateKey:
+			public = &k.PublicKey
+		default:
+			return ErrKeyNotFound
+		}
+
+		if public.Curve != elliptic.P256() || len(signature) != 64 {
+			return ErrSignatureInvalid
+		}
+
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		digest := sha256.Sum256([]byte(signingInput))
+
+		if !ecdsa.Verify(public, digest[:], r, s) {
+			return ErrSignatureInvalid
+		}
+
+		return nil
+	case EdDSA:
+		var public ed25519.PublicKey
+		switch k := key.Key.(type) {
+		case ed25519.PublicKey:
+			public = k
+		case ed25519.PrivateKey:
+			public = k.Public().(ed25519.PublicKey)
+		default:
+			return ErrKeyNotFound
+		}
+
+		if len(public) != ed25519.PublicKeySize || !ed25519.Verify(public, []byte(signingInput), signature) {
+			return ErrSignatureInvalid
+		}
+
+		return nil
+	}
+
+	return ErrAlgorithmNotAllowed
+}
+
+func sign(alg string, key Key, signingInput string) ([]byte, error) {
+	switch alg {
+	case HS256:
+		secret, ok := key.Key.([]byte)
+		if !ok {
+			return nil, errors.New("HS256 key must be []byte")
+		}
+
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(signingInput))
+
+		return mac.Sum(nil), nil
+	case RS256:
+		private, ok := key.Key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("RS256 key must be *rsa.PrivateKey")
+		}
+
+		digest := sha256.Sum256([]byte(signingInput))
+
+		return rsa.SignPKCS1v15(rand.Reader, private, crypto.SHA256, digest[:])
+	case ES256:
+		private, ok := key.Key.(*ecdsa.PrivateKey)
+		if !ok || private.Curve != elliptic.P256() {
+			return nil, errors.New("ES256 key must be *ecdsa.PrivateKey on P-256")
+		}
+
+		digest := sha256.Sum256([]byte(signingInput))
+		r, s, err := ecdsa.Sign(rand.Reader, private, digest[:])
+		if err != nil {
+			return nil, err
+		}
+
+		signature := make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+
+		return signature, nil
+	case EdDSA:
+		private, ok := key.Key.(ed25519.PrivateKey)
+		if !ok {
+			return nil, errors.New("EdDSA key must be ed25519.PrivateKey")
+		}
+
+		return ed25519.Sign(private, []byte(signingInput)), nil
+	}
+
+	return nil, ErrAlgorithmNotAllowed
+}
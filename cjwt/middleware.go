@@ -0,0 +1,93 @@
+package cjwt
+
+import (
+	"context"
+	"errors"
+
+	"github.com/radenrishwan/aci/chttp"
+)
+
+type claimsKey struct{}
+
+type MiddlewareOption struct {
+	Realm    string
+	Verifier *Verifier
+}
+
+// authenticate the bearer token as a JWT, the verified claims are stored on [chttp.Context]
+// and the principal name is the token subject
+func Middleware(option MiddlewareOption) chttp.Middleware {
+	if option.Verifier == nil {
+		panic("jwt middleware verifier is required")
+	}
+
+	bearer := chttp.BearerAuthMiddleware(chttp.BearerAuthOption{
+		Realm: option.Realm,
+		Verifier: func(ctx context.Context, token string) (*chttp.Principal, error) {
+			claims, err := option.Verifier.Verify(token)
+			if err != nil && isTokenError(err) {
+				return nil, errors.Join(chttp.ErrInvalidToken, err)
+			}
+
+			if err != nil {
+				return nil, err
+			}
+
+			return &chttp.Principal{
+				Name:       claims.Subject,
+				Scheme:     "Bearer",
+				Attributes: map[string]any{"claims": claims},
+			}, nil
+		},
+	})
+
+	return func(next chttp.Handler) chttp.Handler {
+		return bearer(func(c chttp.Context) *chttp.Response {
+			if claims, ok := c.Principal().Attributes["claims"].(*Claims); ok {
+				c.Context = WithClaims(c.Context, claims)
+			}
+
+			return next(c)
+		})
+	}
+}
+
+// the token is rejected, other error such as a failed key lookup is not caused by the client
+func isTokenError(err error) bool {
+	for _, target := range []error{
+		ErrTokenMalformed, ErrSignatureInvalid, ErrAlgorithmNotAllowed, ErrKeyNotFound,
+		ErrTokenExpired, ErrTokenNotYetValid, ErrTokenUsedBeforeIssued, ErrTokenMissingExpiry,
+		ErrInvalidIssuer, ErrInvalidAudience,
+	} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func WithClaims(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// get verified claims that is stored by [Middleware]
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(*Claims)
+	return claims, ok
+}
+
+// decode the verified claims into T, it can be used to get custom claims with their types.
+// [ErrNoClaims] is returned if the claims are not stored by [Middleware]
+func ClaimsAs[T any](ctx context.Context) (T, error) {
+	var result T
+
+	claims, ok := ClaimsFromContext(ctx)
+	if !ok {
+		return result, ErrNoClaims
+	}
+
+	err := claims.Decode(&result)
+
+	return result, err
+}
@@ -0,0 +1,35 @@
+package cjwt
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestClaimsAs(t *testing.T) {
+	type custom struct {
+		Subject string `json:"sub"`
+		Role    string `json:"role"`
+	}
+
+	if _, err := ClaimsAs[custom](context.Background()); !errors.Is(err, ErrNoClaims) {
+		t.Fatalf("got %v, want %v", err, ErrNoClaims)
+	}
+
+	key := Key{Key: []byte("secret")}
+	token := signTestToken(t, key, map[string]any{"sub": "user", "role": "admin"})
+
+	claims, err := newTestVerifier(VerifyOption{Keys: NewKeySet(key)}).Verify(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := ClaimsAs[custom](WithClaims(context.Background(), claims))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result != (custom{"user", "admin"}) {
+		t.Fatalf("got %+v", result)
+	}
+}
@@ -0,0 +1,190 @@
+package cwebsocket
+
+import (
+	"encoding/binary"
+	"io"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// how long [Close] waits for the close frame of the peer before the connection is closed
+const CLOSE_TIMEOUT = 5 * time.Second
+
+// the connection is closed by the peer with the status code and the reason.
+// code is [STATUS_CLOSE_NO_STATUS] if the close frame has no status code
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	if e.Reason == "" {
+		return "websocket closed: " + strconv.Itoa(e.Code)
+	}
+
+	return "websocket closed: " + strconv.Itoa(e.Code) + " " + e.Reason
+}
+
+// parse the payload of a close frame, invalid status code and invalid UTF-8 reason
+// will return [WsError] with the close code that must be sent to the peer
+func ParseClosePayload(payload []byte) (*CloseError, error) {
+	if len(payload) == 0 {
+		return &CloseError{Code: STATUS_CLOSE_NO_STATUS}, nil
+	}
+
+	if len(payload) == 1 {
+		return nil, &WsError{Msg: "invalid close payload", Code: STATUS_CLOSE_PROTOCOL_ERR}
+	}
+
+	code := int(binary.BigEndian.Uint16(payload))
+	if !validCloseCode(code) {
+		return nil, &WsError{Msg: "invalid close code", Reason: strconv.Itoa(code), Code: STATUS_CLOSE_PROTOCOL_ERR}
+	}
+
+	if !utf8.Valid(payload[2:]) {
+		return nil, &WsError{Msg: "invalid close reason", Reason: "reason is not valid UTF-8", Code: STATUS_CLOSE_INVALID_PAYLOAD}
+	}
+
+	return &CloseError{Code: code, Reason: string(payload[2:])}, nil
+}
+
+// payload of a close frame, the reason is cut to fit the 125 bytes of a control frame.
+// the code that can not be sent over the wire like [STATUS_CLOSE_NO_STATUS] will send an empty payload
+func ClosePayload(code int, reason string) []byte {
+	if !validCloseCode(code) {
+		return nil
+	}
+
+	if len(reason) > 123 {
+		reason = reason[:123]
+
+		// dont cut in the middle of a rune
+		for !utf8.ValidString(reason) {
+			reason = reason[:len(reason)-1]
+		}
+	}
+
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+
+	return append(payload, reason...)
+}
+
+// status code that can be sent in a close frame
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003:
+		return true
+	case code >= 1007 && code <= 1014:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+
+	return false
+}
+
+// send a close frame without closing the connection, use [Close] to do the closing handshake.
+// the frame is masked if conn is returned by [Dial]
+func WriteClose(conn io.Writer, reason string, code int) error {
+	err := WriteFrame(conn, encodeFrame(true, 0x8, ClosePayload(code, reason), dialed(conn)))
+	if err != nil {
+		return NewWsError("Error sending close signal : ", err.Error())
+	}
+
+	return nil
+}
+
+// close the connection with a specific reason and status code. if conn is also a reader,
+// it waits for the close frame of the peer up to [CLOSE_TIMEOUT] and other frames are discarded.
+// conn must not be read by another goroutine at the same time, use [FrameReader.Close] if the
+// frames are read by a [FrameReader], or send [WriteClose] and let the read loop get the close frame
+func Close(conn io.WriteCloser, reason string, code int) error {
+	r, ok := conn.(io.Reader)
+	if !ok {
+		return closeHandshake(conn, reason, code, nil)
+	}
+
+	return closeHandshake(conn, reason, code, func() (*WsFrame, error) {
+		frame, err := ReadFrame(r, MAX_FRAME_SIZE)
+		if err == nil {
+			observeFrameReceived(frame.Opcode)
+		}
+
+		return frame, err
+	})
+}
+
+// same as [Close], but the close frame of the peer is read by fr, so the bytes that are already
+// buffered by fr are not skipped. it must not be called while another goroutine is reading fr
+func (fr *FrameReader) Close(conn io.WriteCloser, reason string, code int) error {
+	return closeHandshake(conn, reason, code, fr.ReadFrame)
+}
+
+// send the close frame and wait for the close frame of the peer with read, nil read does not wait
+func closeHandshake(conn io.WriteCloser, reason string, code int, read func() (*WsFrame, error)) error {
+	if err := WriteClose(conn, reason, code); err != nil {
+		conn.Close()
+		return err
+	}
+
+	if read != nil {
+		timer := time.AfterFunc(CLOSE_TIMEOUT, func() { conn.Close() })
+
+		for {
+			frame, err := read()
+			if err != nil || frame.Opcode == 0x8 {
+				break
+			}
+		}
+
+		// the connection is already closed by the timer
+		if !timer.Stop() {
+			return nil
+		}
+	}
+
+	if err := conn.Close(); err != nil {
+		return NewWsError("Error closing connection : ", err.Error())
+	}
+
+	return nil
+}
+
+// the client side must mask every frame, it is only known for the connection of [Dial]
+func dialed(conn any) bool {
+	_, ok := conn.(*handshakeConn)
+
+	return ok
+}
+
+// answer the close frame of the peer and close the connection
+func readClose(conn io.Reader, payload []byte) error {
+	closeErr, err := ParseClosePayload(payload)
+
+	wc, ok := conn.(io.WriteCloser)
+	if !ok {
+		if err != nil {
+			return err
+		}
+
+		return closeErr
+	}
+
+	if err != nil {
+		failConnection(wc, err.(*WsError))
+		return err
+	}
+
+	WriteClose(wc, "", closeErr.Code)
+	wc.Close()
+
+	return closeErr
+}
+
+// send a close frame because of the error and close the connection without waiting for the peer
+func failConnection(conn io.WriteCloser, err *WsError) {
+	WriteClose(conn, err.Msg, err.Code)
+	conn.Close()
+}
@@ -0,0 +1,92 @@
+package cwebsocket
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// only the connection of Dial must send masked close frames
+func TestWriteCloseMask(t *testing.T) {
+	tests := []struct {
+		name   string
+		dialed bool
+	}{
+		{"server", false},
+		{"client", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			local, peer := net.Pipe()
+			defer peer.Close()
+
+			var conn io.ReadWriteCloser = local
+			if tt.dialed {
+				conn = &handshakeConn{Conn: local, br: bufio.NewReader(local)}
+			}
+
+			// the close frame of the peer is answered by Read
+			errc := make(chan error, 1)
+			go func() {
+				_, err := Read(conn)
+				errc <- err
+			}()
+
+			if _, err := peer.Write(EncodeFrame(ClosePayload(STATUS_CLOSE_NORMAL_CLOSURE, ""), CLOSE)); err != nil {
+				t.Fatal(err)
+			}
+
+			frame, err := ReadFrame(peer, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if frame.Opcode != 0x8 || frame.Mask != tt.dialed {
+				t.Fatalf("opcode is %d and mask is %v, want close frame with mask %v", frame.Opcode, frame.Mask, tt.dialed)
+			}
+
+			if !bytes.Equal(frame.Payload, ClosePayload(STATUS_CLOSE_NORMAL_CLOSURE, "")) {
+				t.Fatalf("payload is %x", frame.Payload)
+			}
+
+			if err := <-errc; err == nil {
+				t.Fatal("close frame is not returned as error")
+			}
+		})
+	}
+}
+
+// the close frame of the peer that is already buffered by the reader is not skipped
+func TestFrameReaderClose(t *testing.T) {
+	local, peer := net.Pipe()
+	defer peer.Close()
+
+	go func() {
+		frames := append(EncodeMaskedFrame([]byte("hello"), TEXT), EncodeMaskedFrame(ClosePayload(STATUS_CLOSE_NORMAL_CLOSURE, ""), CLOSE)...)
+		peer.Write(frames)
+
+		// read the close frame of the server
+		ReadFrame(peer, 0)
+	}()
+
+	fr := NewFrameReader(local, MAX_FRAME_SIZE)
+	fr.Role = SERVER_ROLE
+
+	frame, err := fr.ReadFrame()
+	if err != nil || string(frame.Payload) != "hello" {
+		t.Fatalf("got %v, %v", frame, err)
+	}
+
+	start := time.Now()
+	if err := fr.Close(local, "", STATUS_CLOSE_NORMAL_CLOSURE); err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(start); elapsed >= CLOSE_TIMEOUT {
+		t.Fatalf("close waited %v for the buffered close frame", elapsed)
+	}
+}
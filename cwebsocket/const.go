@@ -42,6 +42,8 @@ const (
 type WsError struct {
 	Msg    string
 	Reason string
+	// close status code that is sent to the peer, zero if the connection is not closed
+	Code int
 }
 
 func (e *WsError) Error() string {
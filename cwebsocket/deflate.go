@@ -0,0 +1,297 @@
+package cwebsocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const PERMESSAGE_DEFLATE = "permessage-deflate"
+
+// max size of the LZ77 window of deflate
+const maxWindowSize = 1 << 15
+
+// the end of every compressed message, it is removed by the sender
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff}
+
+// configuration of the permessage-deflate extension (RFC 7692)
+type CompressionOption struct {
+	// compression level of compress/flate, zero means flate.DefaultCompression
+	Level int
+	// message that is smaller than the size is sent uncompressed
+	Threshold int
+	// the server compressor is reset for every message, it use less memory but compress worse
+	ServerNoContextTakeover bool
+	// same as ServerNoContextTakeover, but for the client compressor
+	ClientNoContextTakeover bool
+	// requested by the client to limit the window of the server compressor, 8 to 15. zero means 15
+	ServerMaxWindowBits int
+	// sent by the server to limit the window of the client compressor if the client allows it, 8 to 15.
+	// zero means 15. the compressor of this package always use 15 bits
+	ClientMaxWindowBits int
+}
+
+var DefaultCompressionOption = CompressionOption{
+	Level:     flate.DefaultCompression,
+	Threshold: 256,
+}
+
+// negotiated permessage-deflate of a connection, it must not be used by multiple goroutines
+// at the same time for writing, the same for reading
+type Deflate struct {
+	level     int
+	threshold int
+	// reset the compressor of this side or the peer for every message
+	noWriteContext bool
+	noReadContext  bool
+
+	w   *flate.Writer
+	buf bytes.Buffer
+	r   io.ReadCloser
+	// last window of the decompressed messages, used when the peer keeps the context
+	dict []byte
+}
+
+func newDeflate(option *CompressionOption, server bool, params map[string]string) *Deflate {
+	d := &Deflate{
+		level:     option.Level,
+		threshold: option.Threshold,
+	}
+
+	if d.level == 0 || d.level < flate.HuffmanOnly || d.level > flate.BestCompression {
+		d.level = flate.DefaultCompression
+	}
+
+	_, serverNoContext := params["server_no_context_takeover"]
+	_, clientNoContext := params["client_no_context_takeover"]
+	if server {
+		d.noWriteContext, d.noReadContext = serverNoContext, clientNoContext
+	} else {
+		d.noWriteContext, d.noReadContext = clientNoContext, serverNoContext
+	}
+
+	return d
+}
+
+// encode a message as a single frame, text and binary message that is not smaller than
+// the threshold is compressed and sent with RSV1
+func (d *Deflate) EncodeFrame(msg []byte, messageType MessageType, mask bool) []byte {
+	if (messageType != TEXT && messageType != BINARY) || len(msg) < d.threshold {
+		return encodeFrame(true, messageOpcode(messageType), msg, mask)
+	}
+
+	frame := encodeFrame(true, messageOpcode(messageType), d.compress(msg), mask)
+	frame[0] |= 0x40
+
+	return frame
+}
+
+// writer that compress the whole message on Close, then send it as fragments
+func (d *Deflate) NewMessageWriter(w io.Writer, messageType MessageType, fragmentSize int, mask bool) io.WriteCloser {
+	mw := NewMessageWriter(w, messageType, fragmentSize).(*messageWriter)
+	mw.mask = mask
+	mw.deflate = d
+
+	return mw
+}
+
+func (d *Deflate) compress(msg []byte) []byte {
+	d.buf.Reset()
+
+	if d.w == nil {
+		// the level is checked by newDeflate
+		d.w, _ = flate.NewWriter(&d.buf, d.level)
+	} else if d.noWriteContext {
+		d.w.Reset(&d.buf)
+	}
+
+	d.w.Write(msg)
+	d.w.Flush()
+
+	return bytes.Clone(bytes.TrimSuffix(d.buf.Bytes(), deflateTail))
+}
+
+// decompress a message up to maxSize, zero means no limit
+func (d *Deflate) decompress(payload []byte, maxSize int) ([]byte, error) {
+	// the tail and an empty final block, so the reader returns EOF at the end of the message
+	input := io.MultiReader(
+		bytes.NewReader(payload),
+		bytes.NewReader([]byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}),
+	)
+
+	if d.r == nil {
+		d.r = flate.NewReaderDict(input, d.dict)
+	} else {
+		d.r.(flate.Resetter).Reset(input, d.dict)
+	}
+
+	var r io.Reader = d.r
+	if maxSize > 0 {
+		r = io.LimitReader(d.r, int64(maxSize)+1)
+	}
+
+	msg, err := io.ReadAll(r)
+	if err != nil {
+		return nil, &WsError{Msg: "invalid compressed message", Reason: err.Error(), Code: STATUS_CLOSE_INVALID_PAYLOAD}
+	}
+
+	if maxSize > 0 && len(msg) > maxSize {
+		return nil, &WsError{Msg: "message is too big", Reason: "more than " + strconv.Itoa(maxSize) + " bytes", Code: STATUS_CLOSE_MESSAGE_TOO_BIG}
+	}
+
+	if !d.noReadContext {
+		d.dict = append(d.dict, msg...)
+		if len(d.dict) > maxWindowSize {
+			d.dict = bytes.Clone(d.dict[len(d.dict)-maxWindowSize:])
+		}
+	}
+
+	return msg, nil
+}
+
+// select the first offer of the client that can be accepted, it returns the response header value
+func negotiateDeflate(header string, option *CompressionOption) (string, *Deflate) {
+	for _, offer := range parseExtensions(header) {
+		if offer.name != PERMESSAGE_DEFLATE {
+			continue
+		}
+
+		params, ok := offer.params, true
+		response := PERMESSAGE_DEFLATE
+
+		for name, value := range params {
+			switch name {
+			case "server_no_context_takeover", "client_no_context_takeover":
+				ok = ok && value == ""
+			case "server_max_window_bits":
+				// the compressor can not use smaller window
+				ok = ok && value == "15"
+			case "client_max_window_bits":
+				bits, valid := windowBits(value)
+				ok = ok && (value == "" || valid)
+
+				if limit := option.ClientMaxWindowBits; ok && limit >= 8 && limit < 15 && (value == "" || limit < bits) {
+					response += "; client_max_window_bits=" + strconv.Itoa(limit)
+				}
+			default:
+				ok = false
+			}
+		}
+
+		if !ok {
+			continue
+		}
+
+		if _, found := params["server_no_context_takeover"]; found || option.ServerNoContextTakeover {
+			params["server_no_context_takeover"] = ""
+			response += "; server_no_context_takeover"
+		}
+
+		if _, found := params["client_no_context_takeover"]; found || option.ClientNoContextTakeover {
+			params["client_no_context_takeover"] = ""
+			response += "; client_no_context_takeover"
+		}
+
+		return response, newDeflate(option, true, params)
+	}
+
+	return "", nil
+}
+
+// value of Sec-WebSocket-Extensions that is sent by the client
+func deflateOffer(option *CompressionOption) string {
+	offer := PERMESSAGE_DEFLATE
+
+	if option.ServerNoContextTakeover {
+		offer += "; server_no_context_takeover"
+	}
+
+	if option.ClientNoContextTakeover {
+		offer += "; client_no_context_takeover"
+	}
+
+	if option.ServerMaxWindowBits >= 8 && option.ServerMaxWindowBits < 15 {
+		offer += "; server_max_window_bits=" + strconv.Itoa(option.ServerMaxWindowBits)
+	}
+
+	return offer
+}
+
+// check the extension that is accepted by the server, nil is returned if the server does not accept it
+func acceptDeflate(header string, option *CompressionOption) (*Deflate, error) {
+	if header == "" {
+		return nil, nil
+	}
+
+	extensions := parseExtensions(header)
+	if len(extensions) != 1 || extensions[0].name != PERMESSAGE_DEFLATE {
+		return nil, NewWsError("Invalid handshake response", "unexpected extension "+strconv.Quote(header))
+	}
+
+	for name, value := range extensions[0].params {
+		switch name {
+		case "server_no_context_takeover", "client_no_context_takeover":
+		case "server_max_window_bits":
+			if _, ok := windowBits(value); !ok {
+				return nil, NewWsError("Invalid handshake response", "invalid server_max_window_bits")
+			}
+		case "client_max_window_bits":
+			// it is not offered, because the compressor can not use smaller window
+			if value != "15" {
+				return nil, NewWsError("Invalid handshake response", "unsupported client_max_window_bits")
+			}
+		default:
+			return nil, NewWsError("Invalid handshake response", "unknown extension parameter "+strconv.Quote(name))
+		}
+	}
+
+	return newDeflate(option, false, extensions[0].params), nil
+}
+
+func windowBits(value string) (int, bool) {
+	bits, err := strconv.Atoi(value)
+
+	return bits, err == nil && bits >= 8 && bits <= 15
+}
+
+type extension struct {
+	name   string
+	params map[string]string
+}
+
+// parse the value of Sec-WebSocket-Extensions, the extension with a duplicated parameter is skipped
+func parseExtensions(header string) []extension {
+	var extensions []extension
+
+	for _, item := range strings.Split(header, ",") {
+		parts := strings.Split(item, ";")
+
+		ext := extension{
+			name:   strings.ToLower(strings.TrimSpace(parts[0])),
+			params: make(map[string]string),
+		}
+		if ext.name == "" {
+			continue
+		}
+
+		valid := true
+		for _, part := range parts[1:] {
+			name, value, _ := strings.Cut(part, "=")
+			name = strings.ToLower(strings.TrimSpace(name))
+			value = strings.Trim(strings.TrimSpace(value), `"`)
+
+			if _, ok := ext.params[name]; ok || name == "" {
+				valid = false
+			}
+			ext.params[name] = value
+		}
+
+		if valid {
+			extensions = append(extensions, ext)
+		}
+	}
+
+	return extensions
+}
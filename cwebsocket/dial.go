@@ -0,0 +1,211 @@
+package cwebsocket
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/radenrishwan/aci/chttp"
+)
+
+type DialOption struct {
+	// extra headers of the handshake request, like Origin or Authorization
+	Headers map[string]string
+	// sent as Sec-WebSocket-Protocol, the selected one is in the response header
+	Subprotocols []string
+	// used to connect wss url
+	TLSConfig *tls.Config
+	// deadline of the dial and the handshake, zero means no timeout
+	HandshakeTimeout time.Duration
+	// offer permessage-deflate to the server, nil disable it
+	Compression *CompressionOption
+}
+
+var DefaultDialOption = DialOption{
+	HandshakeTimeout: 10 * time.Second,
+}
+
+// connect to a websocket server with ws or wss url and perform the client handshake.
+// every frame that is written to the connection must be masked, see [EncodeMaskedFrame].
+// the frames of [Write], [WriteString], [WriteWithMessageType], [Close], [WriteClose] and [Read] are masked for the connection
+func Dial(ctx context.Context, rawURL string, option *DialOption) (net.Conn, *Handshake, error) {
+	if option == nil {
+		option = &DefaultDialOption
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, nil, NewWsError("Invalid url", err.Error())
+	}
+
+	secure := false
+	switch u.Scheme {
+	case "ws", "http":
+	case "wss", "https":
+		secure = true
+	default:
+		return nil, nil, NewWsError("Invalid url", "unsupported scheme "+strconv.Quote(u.Scheme))
+	}
+
+	addr := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if secure {
+			port = "443"
+		}
+		addr = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	if option.HandshakeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, option.HandshakeTimeout)
+		defer cancel()
+	}
+
+	var conn net.Conn
+	if secure {
+		config := &tls.Config{}
+		if option.TLSConfig != nil {
+			config = option.TLSConfig.Clone()
+		}
+
+		if config.ServerName == "" {
+			config.ServerName = u.Hostname()
+		}
+		config.NextProtos = []string{"http/1.1"}
+
+		conn, err = (&tls.Dialer{Config: config}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	}
+
+	if err != nil {
+		return nil, nil, NewWsError("Error dialing", err.Error())
+	}
+
+	// close the connection to unblock the handshake when ctx is done
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	br, result, err := handshake(conn, u, option)
+	if !stop() {
+		err = ctx.Err()
+	}
+
+	if err != nil {
+		conn.Close()
+		return nil, result, err
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	// the server can send frames right after the handshake response
+	return &handshakeConn{Conn: observeNetConn(conn), br: br}, result, nil
+}
+
+// the handshake is returned with the response if the server refuse the upgrade
+func handshake(conn net.Conn, u *url.URL, option *DialOption) (*bufio.Reader, *Handshake, error) {
+	var nonce [16]byte
+	rand.Read(nonce[:])
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+
+	request := &chttp.Request{
+		Method:  "GET",
+		Version: "HTTP/1.1",
+		Headers: make(map[string]string, len(option.Headers)+6),
+		URL:     u,
+	}
+
+	for name, value := range option.Headers {
+		request.Headers[name] = value
+	}
+
+	request.Headers["Host"] = u.Host
+	request.Headers["Upgrade"] = "websocket"
+	request.Headers["Connection"] = "Upgrade"
+	request.Headers["Sec-WebSocket-Key"] = key
+	request.Headers["Sec-WebSocket-Version"] = "13"
+
+	if len(option.Subprotocols) > 0 {
+		request.Headers["Sec-WebSocket-Protocol"] = strings.Join(option.Subprotocols, ", ")
+	}
+
+	if option.Compression != nil {
+		request.Headers["Sec-WebSocket-Extensions"] = deflateOffer(option.Compression)
+	}
+
+	if err := request.Write(conn); err != nil {
+		return nil, nil, NewWsError("Error sending handshake", err.Error())
+	}
+
+	br := bufio.NewReader(conn)
+
+	resp, err := chttp.ReadResponseHead(br)
+	if err != nil {
+		return nil, nil, NewWsError("Error reading handshake response", err.Error())
+	}
+
+	result := &Handshake{Request: request, Response: resp}
+
+	if resp.Code != 101 {
+		return nil, result, NewWsError("Invalid handshake response", "unexpected status code "+strconv.Itoa(resp.Code))
+	}
+
+	if !containsToken(resp.Headers["Upgrade"], "websocket") || !containsToken(resp.Headers["Connection"], "upgrade") {
+		return nil, result, NewWsError("Invalid handshake response", "missing upgrade header")
+	}
+
+	if resp.Headers["Sec-Websocket-Accept"] != GenerateWebsocketKey(key) {
+		return nil, result, NewWsError("Invalid handshake response", "invalid Sec-WebSocket-Accept")
+	}
+
+	result.Subprotocol = resp.Headers["Sec-Websocket-Protocol"]
+	if result.Subprotocol != "" && !slices.Contains(option.Subprotocols, result.Subprotocol) {
+		return nil, result, NewWsError("Invalid handshake response", "unexpected subprotocol "+strconv.Quote(result.Subprotocol))
+	}
+
+	extensions := resp.Headers["Sec-Websocket-Extensions"]
+	if extensions != "" && option.Compression == nil {
+		return nil, result, NewWsError("Invalid handshake response", "unexpected extension "+strconv.Quote(extensions))
+	}
+
+	if option.Compression != nil {
+		result.Deflate, err = acceptDeflate(extensions, option.Compression)
+		if err != nil {
+			return nil, result, err
+		}
+	}
+
+	return br, result, nil
+}
+
+// comma separated header value contains the token, case insensitive
+func containsToken(value, token string) bool {
+	for _, part := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(part), token) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// read the bytes that are buffered during the handshake first
+type handshakeConn struct {
+	net.Conn
+	br *bufio.Reader
+}
+
+func (c *handshakeConn) Read(p []byte) (int, error) {
+	return c.br.Read(p)
+}
@@ -0,0 +1,87 @@
+package cwebsocket
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// every frame that is written to the dialed connection is accepted by a strict server
+func TestDialWriteMasked(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	type message struct {
+		messageType MessageType
+		payload     string
+	}
+
+	received := make(chan message, 4)
+	errc := make(chan error, 1)
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			errc <- err
+			return
+		}
+		defer conn.Close()
+
+		if _, err := (&Upgrader{}).Upgrade(conn); err != nil {
+			errc <- err
+			return
+		}
+
+		fr := NewFrameReader(conn, MAX_FRAME_SIZE)
+		fr.Role = SERVER_ROLE
+
+		for {
+			messageType, payload, err := fr.ReadMessage()
+			if err != nil {
+				errc <- err
+				return
+			}
+
+			received <- message{messageType, string(payload)}
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, _, err := Dial(ctx, "ws://"+ln.Addr().String()+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := Write(conn, []byte("write")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteString(conn, "write string"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteWithMessageType(conn, "binary", BINARY); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []message{{TEXT, "write"}, {TEXT, "write string"}, {BINARY, "binary"}}
+	for _, w := range want {
+		select {
+		case got := <-received:
+			if got != w {
+				t.Fatalf("got %v, want %v", got, w)
+			}
+		case err := <-errc:
+			t.Fatalf("server error: %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("message is not received")
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package cwebsocket
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"unicode/utf8"
+)
+
+// default size of a fragment that is sent by the writer of [NewMessageWriter]
+const FRAGMENT_SIZE = 4096
+
+var errWriterClosed = errors.New("message writer is closed")
+
+// read the next message, the fragments of a text or binary message are joined into one payload.
+// control frames can be sent between the fragments, they are returned right away and the
+// fragmented message is continued on the next call
+func (fr *FrameReader) ReadMessage() (MessageType, []byte, error) {
+	for {
+		frame, err := fr.ReadFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch frame.Opcode {
+		case 0x8, 0x9, 0xA:
+			if !frame.Fin || frame.Length > 125 {
+				return 0, nil, &WsError{Msg: "invalid control frame", Code: STATUS_CLOSE_PROTOCOL_ERR}
+			}
+
+			return messageType(frame.Opcode), frame.Payload, nil
+		case 0x0:
+			if !fr.fragmented {
+				return 0, nil, &WsError{Msg: "unexpected continuation frame", Code: STATUS_CLOSE_PROTOCOL_ERR}
+			}
+		case 0x1, 0x2:
+			if fr.fragmented {
+				return 0, nil, &WsError{Msg: "expected continuation frame", Code: STATUS_CLOSE_PROTOCOL_ERR}
+			}
+
+			fr.fragmented = true
+			fr.messageType = messageType(frame.Opcode)
+			fr.message = nil
+			fr.compressed = frame.RSV1 && fr.Deflate != nil
+		default:
+			return 0, nil, &WsError{Msg: "unknown opcode", Reason: strconv.Itoa(int(frame.Opcode)), Code: STATUS_CLOSE_PROTOCOL_ERR}
+		}
+
+		if fr.MaxMessageSize > 0 && len(fr.message)+len(frame.Payload) > fr.MaxMessageSize {
+			return 0, nil, &WsError{
+				Msg:    "message is too big",
+				Reason: strconv.Itoa(len(fr.message)+len(frame.Payload)) + " bytes",
+				Code:   STATUS_CLOSE_MESSAGE_TOO_BIG,
+			}
+		}
+
+		// most messages are a single frame, so the payload is not copied
+		if fr.message == nil {
+			fr.message = frame.Payload
+		} else {
+			fr.message = append(fr.message, frame.Payload...)
+		}
+
+		if frame.Fin {
+			message := fr.message
+			fr.fragmented = false
+			fr.message = nil
+
+			if fr.compressed {
+				message, err = fr.Deflate.decompress(message, fr.MaxMessageSize)
+				if err != nil {
+					return 0, nil, err
+				}
+			}
+
+			if fr.Role != ANY_ROLE && fr.messageType == TEXT && !utf8.Valid(message) {
+				return 0, nil, &WsError{Msg: "invalid text message", Reason: "message is not valid UTF-8", Code: STATUS_CLOSE_INVALID_PAYLOAD}
+			}
+
+			return fr.messageType, message, nil
+		}
+	}
+}
+
+func messageType(opcode uint8) MessageType {
+	switch opcode {
+	case 0x2:
+		return BINARY
+	case 0x8:
+		return CLOSE
+	case 0x9:
+		return PING
+	case 0xA:
+		return PONG
+	}
+
+	return TEXT
+}
+
+// writer that send a message as fragments of fragmentSize, the message is finished by Close.
+// control messages can not be fragmented, so they are sent as a single frame on Close
+func NewMessageWriter(w io.Writer, messageType MessageType, fragmentSize int) io.WriteCloser {
+	if fragmentSize <= 0 {
+		fragmentSize = FRAGMENT_SIZE
+	}
+
+	return &messageWriter{
+		w:            w,
+		opcode:       messageOpcode(messageType),
+		fragmentSize: fragmentSize,
+	}
+}
+
+// same as [NewMessageWriter], but every fragment is masked for the client side
+func NewMaskedMessageWriter(w io.Writer, messageType MessageType, fragmentSize int) io.WriteCloser {
+	mw := NewMessageWriter(w, messageType, fragmentSize).(*messageWriter)
+	mw.mask = true
+
+	return mw
+}
+
+type messageWriter struct {
+	w            io.Writer
+	opcode       uint8
+	fragmentSize int
+	buf          []byte
+	mask         bool
+	closed       bool
+	// the whole message is compressed on Close
+	deflate *Deflate
+	rsv1    bool
+}
+
+func (mw *messageWriter) Write(p []byte) (int, error) {
+	if mw.closed {
+		return 0, errWriterClosed
+	}
+
+	mw.buf = append(mw.buf, p...)
+
+	if mw.opcode >= 0x8 || mw.deflate != nil {
+		return len(p), nil
+	}
+
+	// keep the last fragment in the buffer, it is sent with fin on Close
+	for len(mw.buf) > mw.fragmentSize {
+		if err := mw.flush(false, mw.buf[:mw.fragmentSize]); err != nil {
+			return 0, err
+		}
+
+		mw.buf = mw.buf[mw.fragmentSize:]
+	}
+
+	return len(p), nil
+}
+
+func (mw *messageWriter) Close() error {
+	if mw.closed {
+		return errWriterClosed
+	}
+	mw.closed = true
+
+	if mw.deflate == nil || mw.opcode >= 0x8 || len(mw.buf) < mw.deflate.threshold {
+		return mw.flush(true, mw.buf)
+	}
+
+	// only the first fragment has RSV1
+	mw.rsv1 = true
+	payload := mw.deflate.compress(mw.buf)
+
+	for len(payload) > mw.fragmentSize {
+		if err := mw.flush(false, payload[:mw.fragmentSize]); err != nil {
+			return err
+		}
+
+		payload = payload[mw.fragmentSize:]
+	}
+
+	return mw.flush(true, payload)
+}
+
+func (mw *messageWriter) flush(fin bool, payload []byte) error {
+	frame := encodeFrame(fin, mw.opcode, payload, mw.mask)
+	if mw.rsv1 {
+		frame[0] |= 0x40
+		mw.rsv1 = false
+	}
+
+	err := WriteFrame(mw.w, frame)
+	if err != nil {
+		return NewWsError("Error sending message : ", err.Error())
+	}
+
+	// the next fragments are continuation frames
+	mw.opcode = 0x0
+
+	return nil
+}
@@ -0,0 +1,111 @@
+package cwebsocket
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/radenrishwan/aci/chttp"
+)
+
+// websocket instrumentation, enable it with [SetMetrics]
+type Metrics struct {
+	openConnections  *chttp.Gauge
+	connectionsTotal *chttp.Counter
+	framesReceived   *chttp.Counter
+	framesSent       *chttp.Counter
+}
+
+var metrics atomic.Pointer[Metrics]
+
+// create websocket metrics on the registry, [chttp.DefaultRegistry] will be used if registry is nil
+func NewMetrics(registry *chttp.Registry) *Metrics {
+	if registry == nil {
+		registry = chttp.DefaultRegistry
+	}
+
+	return &Metrics{
+		openConnections:  registry.NewGauge("websocket_open_connections", "Number of open websocket connections."),
+		connectionsTotal: registry.NewCounter("websocket_connections", "Total number of upgraded websocket connections."),
+		framesReceived:   registry.NewCounter("websocket_frames_received", "Total number of websocket frames received.", "opcode"),
+		framesSent:       registry.NewCounter("websocket_frames_sent", "Total number of websocket frames sent.", "opcode"),
+	}
+}
+
+// record websocket metrics for the whole package, pass nil to disable it
+func SetMetrics(m *Metrics) {
+	metrics.Store(m)
+}
+
+// count the upgraded connection, the returned conn is counted as open until it is closed.
+// only a net.Conn can be wrapped, other connections are only counted by the total
+func observeConnection(conn io.ReadWriteCloser) io.ReadWriteCloser {
+	if nc, ok := conn.(net.Conn); ok {
+		return observeNetConn(nc)
+	}
+
+	if m := metrics.Load(); m != nil {
+		m.connectionsTotal.Inc()
+	}
+
+	return conn
+}
+
+// same as [observeConnection] for the connection of [Dial]
+func observeNetConn(conn net.Conn) net.Conn {
+	m := metrics.Load()
+	if m == nil {
+		return conn
+	}
+
+	m.connectionsTotal.Inc()
+	m.openConnections.Inc()
+
+	return &countedConn{Conn: conn, metrics: m}
+}
+
+// connection that decrease the open connections gauge once when it is closed
+type countedConn struct {
+	net.Conn
+	metrics   *Metrics
+	closeOnce sync.Once
+}
+
+func (c *countedConn) Close() error {
+	c.closeOnce.Do(func() { c.metrics.openConnections.Dec() })
+
+	return c.Conn.Close()
+}
+
+func observeFrameReceived(opcode uint8) {
+	if m := metrics.Load(); m != nil {
+		m.framesReceived.Inc(opcodeName(opcode))
+	}
+}
+
+func observeFrameSent(opcode uint8) {
+	if m := metrics.Load(); m != nil {
+		m.framesSent.Inc(opcodeName(opcode))
+	}
+}
+
+func opcodeName(opcode uint8) string {
+	switch opcode {
+	case 0x0:
+		return "continuation"
+	case 0x1:
+		return "text"
+	case 0x2:
+		return "binary"
+	case 0x8:
+		return "close"
+	case 0x9:
+		return "ping"
+	case 0xA:
+		return "pong"
+	}
+
+	return "0x" + strconv.FormatUint(uint64(opcode), 16)
+}
@@ -0,0 +1,83 @@
+package cwebsocket
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/radenrishwan/aci/chttp"
+)
+
+// the open connections gauge goes back to zero when the connections are closed, even more than once
+func TestMetricsOpenConnections(t *testing.T) {
+	registry := chttp.NewRegistry()
+	SetMetrics(NewMetrics(registry))
+	defer SetMetrics(nil)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	upgraded := make(chan *Handshake, 1)
+	errc := make(chan error, 1)
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			errc <- err
+			return
+		}
+
+		handshake, err := (&Upgrader{}).Upgrade(conn)
+		if err != nil {
+			conn.Close()
+			errc <- err
+			return
+		}
+
+		upgraded <- handshake
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, _, err := Dial(ctx, "ws://"+ln.Addr().String()+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var handshake *Handshake
+	select {
+	case handshake = <-upgraded:
+	case err := <-errc:
+		t.Fatal(err)
+	}
+
+	assertMetric(t, registry, "websocket_open_connections 2")
+	assertMetric(t, registry, "websocket_connections_total 2")
+
+	handshake.Conn.Close()
+	handshake.Conn.Close()
+	conn.Close()
+	conn.Close()
+
+	assertMetric(t, registry, "websocket_open_connections 0")
+	assertMetric(t, registry, "websocket_connections_total 2")
+}
+
+func assertMetric(t *testing.T, registry *chttp.Registry, sample string) {
+	t.Helper()
+
+	var b strings.Builder
+	if _, err := registry.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(b.String(), sample+"\n") {
+		t.Fatalf("%q is not found in\n%s", sample, b.String())
+	}
+}
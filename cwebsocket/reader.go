@@ -0,0 +1,182 @@
+package cwebsocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"strconv"
+)
+
+// default max payload size of a frame that is used by [Read]
+const MAX_FRAME_SIZE = 1 << 20
+
+// read websocket frames from a buffered connection, a frame can be split across
+// multiple reads and multiple frames can arrive in a single read
+type FrameReader struct {
+	r *bufio.Reader
+	// frame with bigger payload is rejected with [STATUS_CLOSE_MESSAGE_TOO_BIG], zero means no limit
+	MaxFrameSize int
+	// same as MaxFrameSize, but for the joined fragments of [FrameReader.ReadMessage]
+	MaxMessageSize int
+	// every frame is checked by [ValidateFrame] and text message must be valid UTF-8,
+	// [ANY_ROLE] disable the strict validation
+	Role Role
+	// negotiated permessage-deflate, the message with RSV1 is decompressed by [FrameReader.ReadMessage]
+	Deflate *Deflate
+
+	// fragmented message that is not finished yet
+	fragmented  bool
+	messageType MessageType
+	message     []byte
+	compressed  bool
+}
+
+// the max message size is the same as maxFrameSize, change [FrameReader.MaxMessageSize] to allow bigger message
+func NewFrameReader(r io.Reader, maxFrameSize int) *FrameReader {
+	return &FrameReader{
+		r:              bufio.NewReader(r),
+		MaxFrameSize:   maxFrameSize,
+		MaxMessageSize: maxFrameSize,
+	}
+}
+
+// read the next frame, the bytes after the frame are kept for the next call
+func (fr *FrameReader) ReadFrame() (*WsFrame, error) {
+	var validate func(frame *WsFrame) error
+	if fr.Role != ANY_ROLE {
+		validate = func(frame *WsFrame) error {
+			// RSV1 is only allowed on the first frame of a compressed message
+			rsv1 := fr.Deflate != nil && (frame.Opcode == 0x1 || frame.Opcode == 0x2)
+
+			return validateFrame(frame, fr.Role, rsv1)
+		}
+	}
+
+	frame, err := readFrame(fr.r, fr.MaxFrameSize, validate)
+	if err != nil {
+		return nil, err
+	}
+
+	observeFrameReceived(frame.Opcode)
+
+	return frame, nil
+}
+
+// read exactly one frame from r, nothing after the frame is consumed. zero maxFrameSize means no limit.
+// the error of r is returned as is, use [FrameReader] to reduce the read calls on a connection
+func ReadFrame(r io.Reader, maxFrameSize int) (*WsFrame, error) {
+	return readFrame(r, maxFrameSize, nil)
+}
+
+// validate is called with the header of the frame before the payload is read
+func readFrame(r io.Reader, maxFrameSize int, validate func(frame *WsFrame) error) (*WsFrame, error) {
+	var header [8]byte
+
+	if _, err := io.ReadFull(r, header[:2]); err != nil {
+		return nil, err
+	}
+
+	frame := &WsFrame{}
+	frame.Fin = (header[0] & 0x80) != 0
+	frame.RSV1 = (header[0] & 0x40) != 0
+	frame.RSV2 = (header[0] & 0x20) != 0
+	frame.RSV3 = (header[0] & 0x10) != 0
+	frame.Opcode = header[0] & 0x0F
+
+	frame.Mask = (header[1] & 0x80) != 0
+	frame.Length = uint64(header[1] & 0x7F)
+
+	switch frame.Length {
+	case 126:
+		if _, err := io.ReadFull(r, header[:2]); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		frame.Length = uint64(binary.BigEndian.Uint16(header[:2]))
+	case 127:
+		if _, err := io.ReadFull(r, header[:8]); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		frame.Length = binary.BigEndian.Uint64(header[:8])
+	}
+
+	if frame.Length > math.MaxInt64 {
+		return nil, &WsError{Msg: "invalid payload length", Code: STATUS_CLOSE_PROTOCOL_ERR}
+	}
+
+	// check before the payload is allocated
+	if frame.Opcode >= 0x8 && frame.Length > 125 {
+		return nil, &WsError{Msg: "control frame is too big", Reason: strconv.FormatUint(frame.Length, 10) + " bytes", Code: STATUS_CLOSE_PROTOCOL_ERR}
+	}
+
+	if validate != nil {
+		if err := validate(frame); err != nil {
+			return nil, err
+		}
+	}
+
+	if maxFrameSize > 0 && frame.Length > uint64(maxFrameSize) {
+		return nil, &WsError{
+			Msg:    "frame is too big",
+			Reason: strconv.FormatUint(frame.Length, 10) + " bytes",
+			Code:   STATUS_CLOSE_MESSAGE_TOO_BIG,
+		}
+	}
+
+	if frame.Mask {
+		if _, err := io.ReadFull(r, frame.MaskKey[:]); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+	}
+
+	if maxFrameSize > 0 {
+		frame.Payload = make([]byte, frame.Length)
+		if _, err := io.ReadFull(r, frame.Payload); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+	} else {
+		// the length is not trusted without a limit, so the payload grows while it is read
+		payload, err := io.ReadAll(io.LimitReader(r, int64(frame.Length)))
+		if err != nil {
+			return nil, err
+		}
+
+		if uint64(len(payload)) < frame.Length {
+			return nil, io.ErrUnexpectedEOF
+		}
+		frame.Payload = payload
+	}
+
+	if frame.Mask {
+		for i := range frame.Payload {
+			frame.Payload[i] ^= frame.MaskKey[i%4]
+		}
+	}
+
+	return frame, nil
+}
+
+// the connection is closed in the middle of a frame
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
+
+// convert the error of [ReadFrame] into [WsError], the connection is closed with the
+// status code of the error if it has one
+func readError(conn any, err error) error {
+	var wsErr *WsError
+	if !errors.As(err, &wsErr) {
+		return NewWsError("Error reading message : ", err.Error())
+	}
+
+	if wc, ok := conn.(io.WriteCloser); ok && wsErr.Code != 0 {
+		failConnection(wc, wsErr)
+	}
+
+	return wsErr
+}
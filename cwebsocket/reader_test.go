@@ -0,0 +1,72 @@
+package cwebsocket
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// the payload length of the peer is not allocated before the payload is read
+func TestReadFrameLength(t *testing.T) {
+	// text frame that claims 1 TiB of payload but only has "Hello"
+	data := []byte{0x81, 0x7f, 0, 0, 1, 0, 0, 0, 0, 0, 'H', 'e', 'l', 'l', 'o'}
+
+	tests := []struct {
+		name         string
+		maxFrameSize int
+		want         error
+	}{
+		{"no limit", 0, io.ErrUnexpectedEOF},
+		{"limit", MAX_FRAME_SIZE, &WsError{Code: STATUS_CLOSE_MESSAGE_TOO_BIG}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReadFrame(bytes.NewReader(data), tt.maxFrameSize)
+
+			var wsErr *WsError
+			if want, ok := tt.want.(*WsError); ok {
+				if !errors.As(err, &wsErr) || wsErr.Code != want.Code {
+					t.Fatalf("got %v, want close code %d", err, want.Code)
+				}
+
+				return
+			}
+
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// the header is validated before the payload, so the length is not read without a limit
+func TestReadFrameHeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		header []byte
+		role   Role
+	}{
+		// ping that claims 1 TiB of payload
+		{"control frame", []byte{0x89, 0x7f, 0, 0, 1, 0, 0, 0, 0, 0}, ANY_ROLE},
+		// reserved opcode that claims 1 TiB of payload
+		{"reserved opcode", []byte{0x83, 0xff, 0, 0, 1, 0, 0, 0, 0, 0, 1, 2, 3, 4}, SERVER_ROLE},
+		// unmasked frame that claims 1 TiB of payload
+		{"unmasked", []byte{0x82, 0x7f, 0, 0, 1, 0, 0, 0, 0, 0}, SERVER_ROLE},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fr := NewFrameReader(bytes.NewReader(tt.header), 0)
+			fr.Role = tt.role
+
+			_, err := fr.ReadFrame()
+
+			var wsErr *WsError
+			if !errors.As(err, &wsErr) || wsErr.Code != STATUS_CLOSE_PROTOCOL_ERR {
+				t.Fatalf("got %v, want close code %d", err, STATUS_CLOSE_PROTOCOL_ERR)
+			}
+		})
+	}
+}
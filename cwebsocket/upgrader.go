@@ -0,0 +1,214 @@
+package cwebsocket
+
+import (
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/radenrishwan/aci/chttp"
+)
+
+// configuration of the server handshake, the zero value accept every valid handshake without
+// subprotocol and extension
+type Upgrader struct {
+	// subprotocols that are supported by the server in the order of preference, the first one
+	// that is offered by the client is selected. the handshake is accepted without subprotocol
+	// if the client offers none of them
+	Subprotocols []string
+	// reject the handshake with 403 if it returns false, nil accepts every origin
+	CheckOrigin func(request *chttp.Request) bool
+	// extra headers of the 101 response
+	Headers map[string]string
+	// enable permessage-deflate if the client offers it, nil disable it
+	Compression *CompressionOption
+}
+
+// result of a successful handshake
+type Handshake struct {
+	Request *chttp.Request
+	// response that is sent by the server, the body is always empty
+	Response *chttp.Response
+	// selected subprotocol, empty if there is none
+	Subprotocol string
+	// negotiated permessage-deflate, nil if it is not used
+	Deflate *Deflate
+	// upgraded connection that is counted by the open connections gauge until it is closed,
+	// use it instead of the connection that is passed to the upgrader. nil for the client handshake
+	Conn io.ReadWriteCloser
+}
+
+// the handshake request is rejected, the response with the status code is already sent
+// to the client by [Upgrader.Upgrade]
+type HandshakeError struct {
+	Code   int
+	Reason string
+}
+
+func (e *HandshakeError) Error() string {
+	return "websocket handshake failed: " + strconv.Itoa(e.Code) + " " + e.Reason
+}
+
+// response that is sent to the client for the error
+func (e *HandshakeError) Response() *chttp.Response {
+	resp := chttp.NewTextResponse(e.Reason).SetCode(e.Code)
+
+	switch e.Code {
+	case 405:
+		resp.SetHeader("Allow", "GET")
+	case 426:
+		// the client must retry with a supported version
+		resp.SetHeader("Sec-WebSocket-Version", "13")
+		resp.SetHeader("Upgrade", "websocket")
+	}
+
+	return resp
+}
+
+// read the handshake request from the connection and upgrade it
+func (u *Upgrader) Upgrade(conn io.ReadWriteCloser) (*Handshake, error) {
+	request, err := chttp.NewRequest(conn)
+	if err != nil {
+		return nil, NewWsError("Error parsing request", err.Error())
+	}
+
+	return u.UpgradeFromRequest(conn, &request)
+}
+
+// upgrade the connection with the handshake request that is already read, an invalid
+// request is answered with [HandshakeError.Response] and the error is returned
+func (u *Upgrader) UpgradeFromRequest(conn io.Writer, request *chttp.Request) (*Handshake, error) {
+	handshake, err := u.upgrade(conn, request)
+	if err != nil {
+		return nil, err
+	}
+
+	if rwc, ok := conn.(io.ReadWriteCloser); ok {
+		handshake.Conn = observeConnection(rwc)
+	} else {
+		observeConnection(nil)
+	}
+
+	return handshake, nil
+}
+
+// same as [Upgrader.UpgradeFromRequest] without the metrics of the connection
+func (u *Upgrader) upgrade(conn io.Writer, request *chttp.Request) (*Handshake, error) {
+	if err := u.Check(request); err != nil {
+		if werr := err.Response().Write(conn); werr != nil {
+			return nil, NewWsError("Error while upgrading connection : ", werr.Error())
+		}
+
+		return nil, err
+	}
+
+	handshake := u.accept(request)
+
+	// informational response has no Content-Length, so it is not written by chttp.Response
+	response := "HTTP/1.1 101 Switching Protocols\r\n"
+	for name, value := range handshake.Response.Headers {
+		response += name + ": " + value + "\r\n"
+	}
+
+	_, err := io.WriteString(conn, response+"\r\n")
+	if err != nil {
+		return nil, NewWsError("Error while upgrading connection : ", err.Error())
+	}
+
+	return handshake, nil
+}
+
+// upgrade the request of a chttp route, fn is called with the upgraded connection after the
+// routing and the middlewares are done. an invalid request gets [HandshakeError.Response]
+func (u *Upgrader) Handler(c chttp.Context, fn func(conn io.ReadWriteCloser, handshake *Handshake) error) *chttp.Response {
+	if err := u.Check(c.Request); err != nil {
+		return err.Response()
+	}
+
+	handshake := u.accept(c.Request)
+
+	return c.Hijack(handshake.Response, func(conn io.ReadWriteCloser) error {
+		// the hijacked connection is closed by chttp, close the counted one too
+		handshake.Conn = observeConnection(conn)
+		defer handshake.Conn.Close()
+
+		return fn(handshake.Conn, handshake)
+	})
+}
+
+// negotiate the handshake and create the 101 response, the request must be valid
+func (u *Upgrader) accept(request *chttp.Request) *Handshake {
+	handshake := &Handshake{
+		Request: request,
+		Response: &chttp.Response{
+			Code:    101,
+			Headers: make(map[string]string, len(u.Headers)+5),
+		},
+	}
+
+	for name, value := range u.Headers {
+		handshake.Response.Headers[name] = value
+	}
+
+	handshake.Response.Headers["Upgrade"] = "websocket"
+	handshake.Response.Headers["Connection"] = "Upgrade"
+	handshake.Response.Headers["Sec-WebSocket-Accept"] = GenerateWebsocketKey(request.GetHeader("Sec-WebSocket-Key"))
+
+	handshake.Subprotocol = u.selectSubprotocol(request)
+	if handshake.Subprotocol != "" {
+		handshake.Response.Headers["Sec-WebSocket-Protocol"] = handshake.Subprotocol
+	}
+
+	if u.Compression != nil {
+		var extension string
+		extension, handshake.Deflate = negotiateDeflate(request.GetHeader("Sec-WebSocket-Extensions"), u.Compression)
+
+		if extension != "" {
+			handshake.Response.Headers["Sec-WebSocket-Extensions"] = extension
+		}
+	}
+
+	return handshake
+}
+
+// validate the handshake request without writing anything
+func (u *Upgrader) Check(request *chttp.Request) *HandshakeError {
+	if request.Method != "GET" {
+		return &HandshakeError{Code: 405, Reason: "websocket handshake must use GET"}
+	}
+
+	if !containsToken(request.GetHeader("Connection"), "upgrade") {
+		return &HandshakeError{Code: 400, Reason: "Connection header must contain upgrade"}
+	}
+
+	if !containsToken(request.GetHeader("Upgrade"), "websocket") {
+		return &HandshakeError{Code: 400, Reason: "Upgrade header must be websocket"}
+	}
+
+	if request.GetHeader("Sec-WebSocket-Version") != "13" {
+		return &HandshakeError{Code: 426, Reason: "unsupported websocket version"}
+	}
+
+	if request.GetHeader("Sec-WebSocket-Key") == "" {
+		return &HandshakeError{Code: 400, Reason: "Sec-WebSocket-Key is required"}
+	}
+
+	if u.CheckOrigin != nil && !u.CheckOrigin(request) {
+		return &HandshakeError{Code: 403, Reason: "origin is not allowed"}
+	}
+
+	return nil
+}
+
+func (u *Upgrader) selectSubprotocol(request *chttp.Request) string {
+	offered := strings.Split(request.GetHeader("Sec-WebSocket-Protocol"), ",")
+
+	for _, protocol := range u.Subprotocols {
+		for _, offer := range offered {
+			if strings.TrimSpace(offer) == protocol {
+				return protocol
+			}
+		}
+	}
+
+	return ""
+}
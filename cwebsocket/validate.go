@@ -0,0 +1,52 @@
+package cwebsocket
+
+import "strconv"
+
+// side of the connection that receives the frames, it decides which frames must be masked
+type Role int
+
+const (
+	// the frames are not validated strictly
+	ANY_ROLE Role = iota
+	// frames from the client must be masked
+	SERVER_ROLE
+	// frames from the server must not be masked
+	CLIENT_ROLE
+)
+
+// validate a received frame strictly as the role, the error is [WsError] with the close code
+// that must be sent to the peer. reserved bits are not allowed without a negotiated extension
+func ValidateFrame(frame *WsFrame, role Role) error {
+	return validateFrame(frame, role, false)
+}
+
+// allowRSV1 is true if permessage-deflate is negotiated and the frame can be compressed
+func validateFrame(frame *WsFrame, role Role, allowRSV1 bool) error {
+	if (frame.RSV1 && !allowRSV1) || frame.RSV2 || frame.RSV3 {
+		return &WsError{Msg: "reserved bit is set", Code: STATUS_CLOSE_PROTOCOL_ERR}
+	}
+
+	switch frame.Opcode {
+	case 0x0, 0x1, 0x2:
+	case 0x8, 0x9, 0xA:
+		if !frame.Fin {
+			return &WsError{Msg: "fragmented control frame", Code: STATUS_CLOSE_PROTOCOL_ERR}
+		}
+
+		if frame.Length > 125 {
+			return &WsError{Msg: "control frame is too big", Reason: strconv.FormatUint(frame.Length, 10) + " bytes", Code: STATUS_CLOSE_PROTOCOL_ERR}
+		}
+	default:
+		return &WsError{Msg: "unknown opcode", Reason: strconv.Itoa(int(frame.Opcode)), Code: STATUS_CLOSE_PROTOCOL_ERR}
+	}
+
+	if role == SERVER_ROLE && !frame.Mask {
+		return &WsError{Msg: "client frame is not masked", Code: STATUS_CLOSE_PROTOCOL_ERR}
+	}
+
+	if role == CLIENT_ROLE && frame.Mask {
+		return &WsError{Msg: "server frame is masked", Code: STATUS_CLOSE_PROTOCOL_ERR}
+	}
+
+	return nil
+}
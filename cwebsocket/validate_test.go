@@ -0,0 +1,180 @@
+package cwebsocket
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// read a fixture from testdata, it is hex bytes of unmasked frames and # comments
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", name+".hex"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b strings.Builder
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		b.WriteString(strings.Join(strings.Fields(line), ""))
+	}
+
+	frames, err := hex.DecodeString(b.String())
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+
+	return frames
+}
+
+// encode the frames again with or without a mask, the header bits are kept as is
+func remask(t *testing.T, frames []byte, mask bool) []byte {
+	t.Helper()
+
+	var out []byte
+	for len(frames) > 0 {
+		// the fixture is not validated, so the invalid frames can be encoded too
+		frame, err := DecodeFrame(frames)
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames = frames[len(encodeFrame(frame.Fin, frame.Opcode, frame.Payload, false)):]
+
+		encoded := encodeFrame(frame.Fin, frame.Opcode, frame.Payload, mask)
+		if frame.RSV1 {
+			encoded[0] |= 0x40
+		}
+		if frame.RSV2 {
+			encoded[0] |= 0x20
+		}
+		if frame.RSV3 {
+			encoded[0] |= 0x10
+		}
+
+		out = append(out, encoded...)
+	}
+
+	return out
+}
+
+// read every message like a connection of the role, the close payload is parsed too
+func replay(frames []byte, role Role) error {
+	fr := NewFrameReader(bytes.NewReader(frames), MAX_FRAME_SIZE)
+	fr.Role = role
+
+	for {
+		messageType, payload, err := fr.ReadMessage()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if messageType == CLOSE {
+			if _, err := ParseClosePayload(payload); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func errorCode(t *testing.T, err error) int {
+	t.Helper()
+
+	if err == nil {
+		return 0
+	}
+
+	var wsErr *WsError
+	if !errors.As(err, &wsErr) {
+		t.Fatalf("error is not WsError: %v", err)
+	}
+
+	return wsErr.Code
+}
+
+func TestValidateFixtures(t *testing.T) {
+	tests := []struct {
+		fixture string
+		// close code of the error, zero if every frame is valid
+		code int
+	}{
+		{"text", 0},
+		{"fragmented_text", 0},
+		{"ping_between_fragments", 0},
+		{"fragmented_utf8", 0},
+		{"close", 0},
+		{"close_empty", 0},
+		{"reserved_opcode_data", STATUS_CLOSE_PROTOCOL_ERR},
+		{"reserved_opcode_control", STATUS_CLOSE_PROTOCOL_ERR},
+		{"rsv1", STATUS_CLOSE_PROTOCOL_ERR},
+		{"rsv2", STATUS_CLOSE_PROTOCOL_ERR},
+		{"rsv3", STATUS_CLOSE_PROTOCOL_ERR},
+		{"fragmented_ping", STATUS_CLOSE_PROTOCOL_ERR},
+		{"oversized_ping", STATUS_CLOSE_PROTOCOL_ERR},
+		{"unexpected_continuation", STATUS_CLOSE_PROTOCOL_ERR},
+		{"expected_continuation", STATUS_CLOSE_PROTOCOL_ERR},
+		{"close_invalid_code", STATUS_CLOSE_PROTOCOL_ERR},
+		{"close_reserved_code", STATUS_CLOSE_PROTOCOL_ERR},
+		{"close_one_byte", STATUS_CLOSE_PROTOCOL_ERR},
+		{"close_invalid_utf8", STATUS_CLOSE_INVALID_PAYLOAD},
+		{"text_invalid_utf8", STATUS_CLOSE_INVALID_PAYLOAD},
+		{"fragmented_invalid_utf8", STATUS_CLOSE_INVALID_PAYLOAD},
+	}
+
+	roles := []struct {
+		name string
+		role Role
+		// frames that are sent to the role must be masked
+		mask bool
+	}{
+		{"server", SERVER_ROLE, true},
+		{"client", CLIENT_ROLE, false},
+	}
+
+	for _, tt := range tests {
+		frames := readFixture(t, tt.fixture)
+
+		for _, r := range roles {
+			t.Run(tt.fixture+"/"+r.name, func(t *testing.T) {
+				err := replay(remask(t, frames, r.mask), r.role)
+				if code := errorCode(t, err); code != tt.code {
+					t.Fatalf("close code is %d, want %d: %v", code, tt.code, err)
+				}
+			})
+
+			// missing mask for the server and unexpected mask for the client
+			t.Run(tt.fixture+"/"+r.name+"/wrong mask", func(t *testing.T) {
+				err := replay(remask(t, frames, !r.mask), r.role)
+				if code := errorCode(t, err); code != STATUS_CLOSE_PROTOCOL_ERR {
+					t.Fatalf("close code is %d, want %d: %v", code, STATUS_CLOSE_PROTOCOL_ERR, err)
+				}
+			})
+		}
+	}
+}
+
+// strict validation is disabled, so only the framing errors are returned
+func TestValidateAnyRole(t *testing.T) {
+	for _, fixture := range []string{"text", "rsv1", "text_invalid_utf8"} {
+		frames := readFixture(t, fixture)
+
+		for _, mask := range []bool{true, false} {
+			if err := replay(remask(t, frames, mask), ANY_ROLE); err != nil {
+				t.Fatalf("%s: %v", fixture, err)
+			}
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package cwebsocket
 
 import (
+	"crypto/rand"
 	"crypto/sha1"
 	"encoding/base64"
 	"encoding/binary"
@@ -9,120 +10,39 @@ import (
 	"github.com/radenrishwan/aci/chttp"
 )
 
-// upgrade connection to websocket
+// upgrade connection to websocket, the connection is not counted by the open connections gauge,
+// use [Handshake.Conn] of [Upgrader.Upgrade] for it
 func Upgrade(conn io.ReadWriteCloser) (err error) {
-	key := ""
-
 	request, err := chttp.NewRequest(conn)
-
 	if err != nil {
 		return NewWsError("Error parsing request", err.Error())
 	}
 
-	if _, ok := request.Headers["sec-websocket-key"]; ok {
-		key = request.Headers["sec-websocket-key"]
-	}
-
-	if _, ok := request.Headers["Sec-WebSocket-Key"]; ok {
-		key = request.Headers["Sec-WebSocket-Key"]
-	}
-
-	if key == "" {
-		return NewWsError("Sec-WebSocket-Key is required", "")
-	}
-
-	acceptKey := GenerateWebsocketKey(key)
-
-	_, err = conn.Write([]byte(
-		"HTTP/1.1 101 Switching Protocols\r\n" +
-			"Upgrade: websocket\r\n" +
-			"Connection: Upgrade\r\n" +
-			"Sec-WebSocket-Accept: " + acceptKey + "\r\n" +
-			"\r\n",
-	))
-
-	if err != nil {
-		return NewWsError("Error while upgrading connection : ", err.Error())
-	}
-
-	return nil
+	return UpgradeFromRequest(conn, &request)
 }
 
 func UpgradeFromBuffer(conn io.Writer, buff []byte) (err error) {
-	key := ""
-
 	request, err := chttp.NewRequestFromBuffer(buff)
 
 	if err != nil {
 		return NewWsError("Error parsing request", err.Error())
 	}
 
-	if _, ok := request.Headers["sec-websocket-key"]; ok {
-		key = request.Headers["sec-websocket-key"]
-	}
-
-	if _, ok := request.Headers["Sec-WebSocket-Key"]; ok {
-		key = request.Headers["Sec-WebSocket-Key"]
-	}
-
-	if key == "" {
-		return NewWsError("Sec-WebSocket-Key is required", "")
-	}
-
-	acceptKey := GenerateWebsocketKey(key)
-
-	_, err = conn.Write([]byte(
-		"HTTP/1.1 101 Switching Protocols\r\n" +
-			"Upgrade: websocket\r\n" +
-			"Connection: Upgrade\r\n" +
-			"Sec-WebSocket-Accept: " + acceptKey + "\r\n" +
-			"\r\n",
-	))
-
-	if err != nil {
-		return NewWsError("Error while upgrading connection : ", err.Error())
-	}
-
-	return nil
+	return UpgradeFromRequest(conn, &request)
 }
 
 func UpgradeFromRequest(conn io.Writer, request *chttp.Request) (err error) {
-	key := ""
-
-	if _, ok := request.Headers["sec-websocket-key"]; ok {
-		key = request.Headers["sec-websocket-key"]
-	}
-
-	if _, ok := request.Headers["Sec-WebSocket-Key"]; ok {
-		key = request.Headers["Sec-WebSocket-Key"]
-	}
-
-	if key == "" {
-		return NewWsError("Sec-WebSocket-Key is required", "")
-	}
-
-	acceptKey := GenerateWebsocketKey(key)
-
-	_, err = conn.Write([]byte(
-		"HTTP/1.1 101 Switching Protocols\r\n" +
-			"Upgrade: websocket\r\n" +
-			"Connection: Upgrade\r\n" +
-			"Sec-WebSocket-Accept: " + acceptKey + "\r\n" +
-			"\r\n",
-	))
-
-	if err != nil {
-		return NewWsError("Error while upgrading connection : ", err.Error())
+	_, err = (&Upgrader{}).upgrade(conn, request)
+	if err == nil {
+		observeConnection(nil)
 	}
 
-	return nil
+	return err
 }
 
-// write a websocket frame to the connection
+// write a websocket frame to the connection, the frame is masked if conn is returned by [Dial]
 func Write(conn io.Writer, msg []byte) error {
-	frame := EncodeFrame(msg, TEXT)
-
-	_, err := conn.Write(frame)
+	err := WriteFrame(conn, encodeFrame(true, 0x1, msg, dialed(conn)))
 	if err != nil {
 		return NewWsError("Error sending message : ", err.Error())
 	}
@@ -135,11 +55,9 @@ func WriteString(conn io.Writer, msg string) error {
 	return Write(conn, []byte(msg))
 }
 
-// write a websocket frame to the connection with a specific message type
+// write a websocket frame to the connection with a specific message type, the frame is masked if conn is returned by [Dial]
 func WriteWithMessageType(conn io.Writer, msg string, messageType MessageType) error {
-	frame := EncodeFrame([]byte(msg), messageType)
-
-	_, err := conn.Write(frame)
+	err := WriteFrame(conn, encodeFrame(true, messageOpcode(messageType), []byte(msg), dialed(conn)))
 	if err != nil {
 		return NewWsError("Error sending message : ", err.Error())
 	}
@@ -147,18 +65,30 @@ func WriteWithMessageType(conn io.Writer, msg string, messageType MessageType) e
 	return nil
 }
 
-// get the payload from the connection, if you want to get the raw frame, use DecodeFrame
-func Read(conn io.Reader) ([]byte, error) {
-	buf := make([]byte, 1024)
+// write a frame that is already encoded, the error of conn is returned as is
+func WriteFrame(conn io.Writer, frame []byte) error {
+	if _, err := conn.Write(frame); err != nil {
+		return err
+	}
+
+	observeFrameSent(frame[0] & 0x0F)
 
-	n, err := conn.Read(buf)
+	return nil
+}
+
+// get the payload of the next frame from the connection, if you want to get the raw frame, use ReadFrame.
+// the frame bigger than [MAX_FRAME_SIZE] will close the connection with [STATUS_CLOSE_MESSAGE_TOO_BIG]
+// the close frame of the peer is answered and returned as [CloseError]
+func Read(conn io.Reader) ([]byte, error) {
+	f, err := ReadFrame(conn, MAX_FRAME_SIZE)
 	if err != nil {
-		return nil, NewWsError("Error reading message : ", err.Error())
+		return nil, readError(conn, err)
 	}
 
-	f, err := DecodeFrame(buf[:n])
-	if err != nil {
-		return nil, NewWsError("Error decoding frame : ", err.Error())
+	observeFrameReceived(f.Opcode)
+
+	if f.Opcode == 0x8 {
+		return nil, readClose(conn, f.Payload)
 	}
 
 	return f.Payload, nil
@@ -166,33 +96,38 @@ func Read(conn io.Reader) ([]byte, error) {
 
 // encode a websocket frame to be sent over the connection
 func EncodeFrame(msg []byte, messageType MessageType) []byte {
-	frame := make([]byte, 0)
-	switch messageType {
-	case TEXT:
-		frame = append(frame, MESSAGE_FRAME)
-	case BINARY:
-		frame = append(frame, BINARY_FRAME)
-	case PING:
-		frame = append(frame, PING_FRAME)
-	case PONG:
-		frame = append(frame, PONG_FRAME)
-	case CLOSE:
-		frame = append(frame, CLOSE_FRAME)
-	default:
-		frame = append(frame, MESSAGE_FRAME)
+	return encodeFrame(true, messageOpcode(messageType), msg, false)
+}
+
+// encode a websocket frame with a random mask key, every frame that is sent by a client must be masked
+func EncodeMaskedFrame(msg []byte, messageType MessageType) []byte {
+	return encodeFrame(true, messageOpcode(messageType), msg, true)
+}
+
+func encodeFrame(fin bool, opcode uint8, msg []byte, mask bool) []byte {
+	frame := make([]byte, 0, len(msg)+14)
+	if fin {
+		frame = append(frame, 0x80|opcode)
+	} else {
+		frame = append(frame, opcode)
+	}
+
+	var maskBit byte
+	if mask {
+		maskBit = 0x80
 	}
 
 	length := len(msg)
 	if length < 126 {
-		frame = append(frame, byte(length))
+		frame = append(frame, maskBit|byte(length))
 	} else if length <= 0xFFFF {
-		frame = append(frame, 126)
+		frame = append(frame, maskBit|126)
 
 		// add length as 16-bit unsigned integer
 		frame = append(frame, byte(length>>8))
 		frame = append(frame, byte(length&0xFF))
 	} else {
-		frame = append(frame, 127)
+		frame = append(frame, maskBit|127)
 
 		// add length as 64-bit unsigned integer
 		for i := 7; i >= 0; i-- {
@@ -200,10 +135,36 @@ func EncodeFrame(msg []byte, messageType MessageType) []byte {
 		}
 	}
 
-	frame = append(frame, msg...)
+	if mask {
+		var key [4]byte
+		rand.Read(key[:])
+
+		frame = append(frame, key[:]...)
+		for i, b := range msg {
+			frame = append(frame, b^key[i%4])
+		}
+	} else {
+		frame = append(frame, msg...)
+	}
+
 	return frame
 }
 
+func messageOpcode(messageType MessageType) uint8 {
+	switch messageType {
+	case BINARY:
+		return 0x2
+	case PING:
+		return 0x9
+	case PONG:
+		return 0xA
+	case CLOSE:
+		return 0x8
+	}
+
+	return 0x1
+}
+
 // decode a websocket frame from the connection
 func DecodeFrame(data []byte) (*WsFrame, error) {
 	if len(data) < 2 {
@@ -265,33 +226,6 @@ func DecodeFrame(data []byte) (*WsFrame, error) {
 	return frame, nil
 }
 
-// close the connection with a specific reason and status code
-func Close(conn io.WriteCloser, reason string, code int) error {
-	// send close normal closue
-	closeMSG := make([]byte, 0)
-
-	// add status code on the first 2 byte
-	closeMSG = append(closeMSG, byte(code>>8))
-	closeMSG = append(closeMSG, byte(code&0xFF))
-
-	// add reason
-	closeMSG = append(closeMSG, []byte(reason)...)
-
-	frame := EncodeFrame(closeMSG, CLOSE)
-
-	_, err := conn.Write(frame)
-	if err != nil {
-		return NewWsError("Error sending close signal : ", err.Error())
-	}
-
-	err = conn.Close()
-	if err != nil {
-		return NewWsError("Error closing connection : ", err.Error())
-	}
-
-	return nil
-}
-
 func GenerateWebsocketKey(key string) string {
 	sha := sha1.New()
 	sha.Write([]byte(key))
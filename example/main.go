@@ -5,41 +5,51 @@ import (
 	"log"
 	"log/slog"
 	"net"
+	"os"
+	"time"
 
 	"github.com/radenrishwan/aci/chttp"
 	"github.com/radenrishwan/aci/cwebsocket"
+	"github.com/radenrishwan/aci/websocket"
 )
 
+// run the example by name, e.g. "go run ./example route", default is the websocket example
 func main() {
-	webscoketExample()
+	name := ""
+	if len(os.Args) > 1 {
+		name = os.Args[1]
+	}
+
+	switch name {
+	case "http":
+		httpExample()
+	case "route":
+		websocketRouteExample()
+	case "net":
+		netExample()
+	default:
+		webscoketExample()
+	}
 }
 
 func httpExample() {
-	server, err := net.Listen("tcp", ":8080")
-	if err != nil {
-		log.Fatalln(err)
-	}
+	slog.SetDefault(slog.New(chttp.NewLogHandler(slog.NewTextHandler(os.Stdout, nil))))
 
 	router := chttp.NewRouter()
+	router.Use(chttp.RequestIDMiddleware(nil), chttp.TimeoutMiddleware(5*time.Second))
 
-	router.HandleFunc("/", func(r chttp.Request) *chttp.Response {
+	router.HandleFunc("/", func(c chttp.Context) *chttp.Response {
 		return chttp.NewTextResponse("OK")
 	})
 
-	router.HandleFunc("/hello", func(r chttp.Request) *chttp.Response {
+	router.HandleFunc("/hello", func(c chttp.Context) *chttp.Response {
+		slog.InfoContext(c, "saying hello")
+
 		return chttp.NewTextResponse("Hello")
 	})
 
-	for {
-		conn, _ := server.Accept()
-
-		err := router.Execute(conn)
-		if err != nil {
-			log.Println(err)
-		}
-
-		conn.Close()
-	}
+	server := chttp.NewServer(":8080", router)
+	log.Fatalln(server.ListenAndServe())
 }
 
 func webscoketExample() {
@@ -63,6 +73,11 @@ func webscoketExample() {
 			for {
 				msg, err := cwebsocket.Read(conn)
 				if err != nil {
+					// the close frame is already answered by Read
+					if _, ok := err.(*cwebsocket.CloseError); ok {
+						return
+					}
+
 					// conver err into WsError
 					err, ok := err.(*cwebsocket.WsError)
 					if ok {
@@ -93,6 +108,28 @@ func webscoketExample() {
 	}
 }
 
+func websocketRouteExample() {
+	router := chttp.NewRouter()
+	router.Use(chttp.RequestIDMiddleware(nil))
+
+	ws := websocket.NewWebsocket(nil)
+
+	// the request is upgraded after the middlewares, the connection is closed when the function returns
+	router.HandleFunc("/ws", ws.Handler(func(client websocket.Client) {
+		for {
+			msg, err := client.Read()
+			if err != nil {
+				return
+			}
+
+			client.Send("Hello, " + string(msg))
+		}
+	}))
+
+	server := chttp.NewServer(":8080", router)
+	log.Fatalln(server.ListenAndServe())
+}
+
 func netExample() {
 	server, err := net.Listen("tcp", ":8080")
 	if err != nil {
@@ -0,0 +1,106 @@
+package websocket
+
+import (
+	"time"
+
+	"github.com/radenrishwan/aci/cwebsocket"
+)
+
+// start the closing handshake, it waits for the close frame of the peer up to
+// [WSOption.CloseTimeout] before the connection is closed. the messages that are
+// received while waiting are discarded
+func (client *Client) Close(reason string, code int) error {
+	defer client.shutdown()
+
+	// the handshake is already started by us or by the peer
+	if !client.state.closeSent.CompareAndSwap(false, true) {
+		return nil
+	}
+
+	timeout := client.option.CloseTimeout
+	if timeout <= 0 {
+		timeout = cwebsocket.CLOSE_TIMEOUT
+	}
+
+	// the messages that are queued by SendAsync are sent before the close frame
+	client.flush(timeout)
+
+	if err := client.writeClose(reason, code); err != nil {
+		return err
+	}
+
+	// the close frame will be received by the running ReadMessage
+	if client.state.reading.Load() > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		select {
+		case <-client.state.closeReceived:
+		case <-client.state.done:
+		case <-timer.C:
+		}
+
+		return nil
+	}
+
+	timer := time.AfterFunc(timeout, client.shutdown)
+	defer timer.Stop()
+
+	for {
+		if _, _, err := client.ReadMessage(); err != nil {
+			return nil
+		}
+	}
+}
+
+// handle the close frame of the peer, our close frame is sent back if we are not the one
+// who start the handshake
+func (client *Client) receiveClose(payload []byte) error {
+	closeErr, err := cwebsocket.ParseClosePayload(payload)
+	if err != nil {
+		client.fail(err.(*cwebsocket.WsError))
+		return err
+	}
+
+	client.state.hookMu.RLock()
+	onClose := client.state.onClose
+	client.state.hookMu.RUnlock()
+
+	if onClose != nil {
+		onClose(closeErr.Code, closeErr.Reason)
+	}
+
+	if client.state.closeSent.CompareAndSwap(false, true) {
+		client.writeClose("", closeErr.Code)
+		client.shutdown()
+	} else {
+		client.state.receivedOnce.Do(func() { close(client.state.closeReceived) })
+	}
+
+	return closeErr
+}
+
+// close the connection because of a protocol error without waiting for the peer
+func (client *Client) fail(err *cwebsocket.WsError) {
+	if client.state.closeSent.CompareAndSwap(false, true) {
+		client.writeClose(err.Msg, err.Code)
+	}
+
+	client.shutdown()
+}
+
+// the close frame of the dialed connection is masked by cwebsocket.WriteClose
+func (client *Client) writeClose(reason string, code int) error {
+	client.state.writeMu.Lock()
+	defer client.state.writeMu.Unlock()
+
+	if client.state.closeWritten {
+		return ErrClientClosed
+	}
+
+	client.setWriteDeadline()
+
+	client.state.closeWritten = true
+
+	return cwebsocket.WriteClose(client.Conn, reason, code)
+}
@@ -1,18 +1,97 @@
 package websocket
 
-import "io"
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/radenrishwan/aci/chttp"
+	"github.com/radenrishwan/aci/cwebsocket"
+)
 
 type Websocket struct {
 	Option *WSOption
 }
 
 type Client struct {
-	Conn   io.ReadWriteCloser
-	option *WSOption
+	Conn io.ReadWriteCloser
+	// subprotocol that is selected by the server, empty if there is none
+	Subprotocol string
+	option      *WSOption
+	reader      *cwebsocket.FrameReader
+	// frames are masked if the client is created by [Dial]
+	mask bool
+	// negotiated permessage-deflate, the writes are serialized by the write lock
+	deflate *cwebsocket.Deflate
+	// shared by the copies of the client
+	state *clientState
+}
+
+type clientState struct {
+	// the pinger and the reader can write a frame at the same time as the user
+	writeMu sync.Mutex
+	// no frame can be sent after the close frame, it is guarded by writeMu
+	closeWritten bool
+	// held from the first frame of a text or binary message until the last fragment is written,
+	// so only control frames can be sent between the fragments. it is locked before writeMu
+	messageMu sync.Mutex
+
+	// send queue of SendAsync, it is created on the first call
+	queueMu sync.Mutex
+	queue   chan outbound
+
+	hookMu  sync.RWMutex
+	onPing  func(payload []byte)
+	onPong  func(payload []byte)
+	onClose func(code int, reason string)
+
+	// unix nano of the last pong, used when the conn has no read deadline
+	lastPong  atomic.Int64
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// closing handshake, closeReceived is closed when the peer answer our close frame
+	closeSent     atomic.Bool
+	closeReceived chan struct{}
+	receivedOnce  sync.Once
+	// number of ReadMessage that is running, Close will read the close frame by itself if it is zero
+	reading atomic.Int32
 }
 
 type WSOption struct {
+	// max payload size of a message including all of its fragments, bigger message will close
+	// the connection with [cwebsocket.STATUS_CLOSE_MESSAGE_TOO_BIG]. default is [cwebsocket.MAX_FRAME_SIZE]
 	MsgMaxSize int
+	// size of the fragments that is sent by [Client.NextWriter], default is [cwebsocket.FRAGMENT_SIZE]
+	FragmentSize int
+	// start a span for every upgrade, nil means tracing is disabled
+	Tracer chttp.Tracer
+	// send a ping periodically to detect idle peer, zero disable it
+	PingInterval time.Duration
+	// the connection is closed if there is no pong within the duration, the read deadline is
+	// refreshed on every pong. default is twice PingInterval, zero PingInterval disable it
+	PongWait time.Duration
+	// subprotocols that are supported by the server, see [cwebsocket.Upgrader]
+	Subprotocols []string
+	// reject the upgrade with 403 if it returns false, nil accepts every origin
+	CheckOrigin func(request *chttp.Request) bool
+	// extra headers of the upgrade response
+	Headers map[string]string
+	// enable permessage-deflate on upgrade if the client offers it, nil disable it.
+	// the client side is configured by [cwebsocket.DialOption]
+	Compression *cwebsocket.CompressionOption
+	// skip the strict protocol validation of the received frames, see [cwebsocket.ValidateFrame]
+	Lenient bool
+	// how long Close waits for the close frame of the peer, default is [cwebsocket.CLOSE_TIMEOUT]
+	CloseTimeout time.Duration
+	// deadline of every frame that is written, the connection is closed if it is exceeded
+	// because the frame may be written partially. zero means no deadline
+	WriteTimeout time.Duration
+	// max number of messages that are queued by [Client.SendAsync], default is [SEND_QUEUE_SIZE]
+	SendQueueSize int
+	// what [Client.SendAsync] does when the queue is full, default is [SLOW_CONSUMER_BLOCK]
+	SlowConsumerPolicy SlowConsumerPolicy
 }
 
 var DefaultWSOption = WSOption{
@@ -0,0 +1,128 @@
+package websocket
+
+import (
+	"time"
+
+	"github.com/radenrishwan/aci/cwebsocket"
+)
+
+// called after a ping is received, the pong is already sent
+func (client *Client) OnPing(fn func(payload []byte)) {
+	client.state.hookMu.Lock()
+	defer client.state.hookMu.Unlock()
+
+	client.state.onPing = fn
+}
+
+// called after a pong is received
+func (client *Client) OnPong(fn func(payload []byte)) {
+	client.state.hookMu.Lock()
+	defer client.state.hookMu.Unlock()
+
+	client.state.onPong = fn
+}
+
+// called when a close frame is received, code is [cwebsocket.STATUS_CLOSE_NO_STATUS] if the peer
+// does not send a status code
+func (client *Client) OnClose(fn func(code int, reason string)) {
+	client.state.hookMu.Lock()
+	defer client.state.hookMu.Unlock()
+
+	client.state.onClose = fn
+}
+
+// send a ping, the payload can not be longer than 125 bytes
+func (client *Client) Ping(payload []byte) error {
+	if len(payload) > 125 {
+		return cwebsocket.NewWsError("Error sending ping : ", "payload is longer than 125 bytes")
+	}
+
+	return client.write(payload, cwebsocket.PING)
+}
+
+// handle ping and pong that is received by ReadMessage
+func (client *Client) handleControl(messageType cwebsocket.MessageType, payload []byte) {
+	client.state.hookMu.RLock()
+	onPing, onPong := client.state.onPing, client.state.onPong
+	client.state.hookMu.RUnlock()
+
+	switch messageType {
+	case cwebsocket.PING:
+		// the error will be returned by the next read or write
+		client.write(payload, cwebsocket.PONG)
+
+		if onPing != nil {
+			onPing(payload)
+		}
+	case cwebsocket.PONG:
+		client.state.lastPong.Store(time.Now().UnixNano())
+		client.refreshReadDeadline()
+
+		if onPong != nil {
+			onPong(payload)
+		}
+	}
+}
+
+func (client *Client) pongWait() time.Duration {
+	if client.option.PingInterval <= 0 {
+		return 0
+	}
+
+	if client.option.PongWait > 0 {
+		return client.option.PongWait
+	}
+
+	return 2 * client.option.PingInterval
+}
+
+// extend the read deadline of the conn, it returns false if the conn has no deadline
+func (client *Client) refreshReadDeadline() bool {
+	wait := client.pongWait()
+	if wait <= 0 {
+		return true
+	}
+
+	conn, ok := client.Conn.(interface{ SetReadDeadline(t time.Time) error })
+	if !ok {
+		return false
+	}
+
+	conn.SetReadDeadline(time.Now().Add(wait))
+
+	return true
+}
+
+// send ping periodically until the client is closed, the conn is closed if the peer is idle
+func (client *Client) pinger() {
+	ticker := time.NewTicker(client.option.PingInterval)
+	defer ticker.Stop()
+
+	// the idle peer is detected by the read deadline, unless the conn does not support it
+	checkPong := !client.refreshReadDeadline()
+	client.state.lastPong.Store(time.Now().UnixNano())
+
+	for {
+		select {
+		case <-client.state.done:
+			return
+		case <-ticker.C:
+			if checkPong && time.Since(time.Unix(0, client.state.lastPong.Load())) > client.pongWait() {
+				client.shutdown()
+				return
+			}
+
+			if client.Ping(nil) != nil {
+				return
+			}
+		}
+	}
+}
+
+// stop the pinger and close the conn
+func (client *Client) shutdown() {
+	client.state.closeOnce.Do(func() {
+		close(client.state.done)
+		client.Conn.Close()
+	})
+}
@@ -0,0 +1,144 @@
+package websocket
+
+import (
+	"errors"
+	"time"
+
+	"github.com/radenrishwan/aci/cwebsocket"
+)
+
+// what SendAsync does when the send queue is full
+type SlowConsumerPolicy int
+
+const (
+	// wait until the queue has space for the message
+	SLOW_CONSUMER_BLOCK SlowConsumerPolicy = iota
+	// discard the message and return [ErrSendQueueFull]
+	SLOW_CONSUMER_DROP
+	// close the connection without the closing handshake and return [ErrSendQueueFull]
+	SLOW_CONSUMER_DISCONNECT
+)
+
+// default size of the send queue, see [WSOption.SendQueueSize]
+const SEND_QUEUE_SIZE = 64
+
+var (
+	ErrSendQueueFull = errors.New("send queue is full")
+	ErrClientClosed  = errors.New("websocket client is closed")
+)
+
+// message in the send queue, flushed is closed by the writer instead of writing if it is not nil
+type outbound struct {
+	msg         []byte
+	messageType cwebsocket.MessageType
+	flushed     chan struct{}
+}
+
+// queue a message that is sent by the writer goroutine of the client, the queued messages are
+// sent in order but not in order with [Client.Send]. msg must not be modified after it is queued.
+// a full queue is handled by [WSOption.SlowConsumerPolicy]
+func (client *Client) SendAsync(msg []byte, messageType cwebsocket.MessageType) error {
+	if client.state.closeSent.Load() {
+		return ErrClientClosed
+	}
+
+	queue := client.sendQueue()
+	out := outbound{msg: msg, messageType: messageType}
+
+	select {
+	case queue <- out:
+		return nil
+	case <-client.state.done:
+		return ErrClientClosed
+	default:
+	}
+
+	switch client.option.SlowConsumerPolicy {
+	case SLOW_CONSUMER_DROP:
+		return ErrSendQueueFull
+	case SLOW_CONSUMER_DISCONNECT:
+		// the writer may be blocked by the peer, so the close frame can not be sent
+		client.shutdown()
+		return ErrSendQueueFull
+	}
+
+	select {
+	case queue <- out:
+		return nil
+	case <-client.state.done:
+		return ErrClientClosed
+	}
+}
+
+// create the send queue and start the writer on the first call
+func (client *Client) sendQueue() chan outbound {
+	client.state.queueMu.Lock()
+	defer client.state.queueMu.Unlock()
+
+	if client.state.queue == nil {
+		size := client.option.SendQueueSize
+		if size <= 0 {
+			size = SEND_QUEUE_SIZE
+		}
+
+		client.state.queue = make(chan outbound, size)
+		go client.writer(client.state.queue)
+	}
+
+	return client.state.queue
+}
+
+// write the queued messages until the client is closed, the connection is closed if a write fails
+func (client *Client) writer(queue chan outbound) {
+	for {
+		select {
+		case <-client.state.done:
+			return
+		case out := <-queue:
+			if out.flushed != nil {
+				close(out.flushed)
+				continue
+			}
+
+			err := client.write(out.msg, out.messageType)
+			if errors.Is(err, ErrClientClosed) {
+				return
+			}
+
+			if err != nil {
+				client.shutdown()
+				return
+			}
+		}
+	}
+}
+
+// wait until the messages that are queued before are written, up to the timeout
+func (client *Client) flush(timeout time.Duration) {
+	client.state.queueMu.Lock()
+	queue := client.state.queue
+	client.state.queueMu.Unlock()
+
+	if queue == nil {
+		return
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	flushed := make(chan struct{})
+
+	select {
+	case queue <- outbound{flushed: flushed}:
+	case <-client.state.done:
+		return
+	case <-timer.C:
+		return
+	}
+
+	select {
+	case <-flushed:
+	case <-client.state.done:
+	case <-timer.C:
+	}
+}
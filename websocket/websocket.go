@@ -1,8 +1,13 @@
 package websocket
 
 import (
+	"context"
+	"errors"
 	"io"
+	"net"
+	"time"
 
+	"github.com/radenrishwan/aci/chttp"
 	"github.com/radenrishwan/aci/cwebsocket"
 )
 
@@ -17,50 +22,334 @@ func NewWebsocket(option *WSOption) (ws Websocket) {
 }
 
 func (ws *Websocket) Upgrade(conn io.ReadWriteCloser) (client Client, err error) {
-	err = cwebsocket.Upgrade(conn)
+	var handshake *cwebsocket.Handshake
+	if ws.Option.Tracer != nil {
+		handshake, err = ws.upgradeWithSpan(conn)
+	} else {
+		handshake, err = ws.upgrader().Upgrade(conn)
+	}
+
 	if err != nil {
 		return Client{}, err
 	}
 
-	client.Conn = conn
-	client.option = ws.Option
+	return newClient(handshake.Conn, ws.Option, handshake, false), nil
+}
+
+// handler of a chttp route that upgrades the request, fn is called with the client after
+// the routing and the middlewares are done and the connection is closed when fn returns
+func (ws *Websocket) Handler(fn func(client Client)) chttp.Handler {
+	return func(c chttp.Context) *chttp.Response {
+		return ws.upgrader().Handler(c, func(conn io.ReadWriteCloser, handshake *cwebsocket.Handshake) error {
+			client := newClient(conn, ws.Option, handshake, false)
+			defer client.shutdown()
+
+			fn(client)
+
+			return nil
+		})
+	}
+}
 
-	return client, nil
+func (ws *Websocket) upgrader() *cwebsocket.Upgrader {
+	return &cwebsocket.Upgrader{
+		Subprotocols: ws.Option.Subprotocols,
+		CheckOrigin:  ws.Option.CheckOrigin,
+		Headers:      ws.Option.Headers,
+		Compression:  ws.Option.Compression,
+	}
+}
+
+func newClient(conn io.ReadWriteCloser, option *WSOption, handshake *cwebsocket.Handshake, mask bool) Client {
+	// the payload length is sent by the peer, so the size is always limited
+	maxSize := option.MsgMaxSize
+	if maxSize <= 0 {
+		maxSize = cwebsocket.MAX_FRAME_SIZE
+	}
+
+	client := Client{
+		Conn:        conn,
+		Subprotocol: handshake.Subprotocol,
+		option:      option,
+		reader:      cwebsocket.NewFrameReader(conn, maxSize),
+		mask:        mask,
+		deflate:     handshake.Deflate,
+		state: &clientState{
+			done:          make(chan struct{}),
+			closeReceived: make(chan struct{}),
+		},
+	}
+
+	client.reader.Deflate = handshake.Deflate
+
+	if !option.Lenient {
+		client.reader.Role = cwebsocket.SERVER_ROLE
+		if mask {
+			client.reader.Role = cwebsocket.CLIENT_ROLE
+		}
+	}
+
+	if option.PingInterval > 0 {
+		go client.pinger()
+	}
+
+	return client
+}
+
+// upgrade the connection inside a span, the parent is taken from the traceparent header
+func (ws *Websocket) upgradeWithSpan(conn io.ReadWriteCloser) (*cwebsocket.Handshake, error) {
+	request, err := chttp.NewRequest(conn)
+	if err != nil {
+		return nil, cwebsocket.NewWsError("Error parsing request", err.Error())
+	}
+
+	ctx := context.Background()
+	if parent, err := chttp.ParseTraceparent(request.GetHeader(chttp.HeaderTraceparent)); err == nil {
+		parent.TraceState = request.GetHeader(chttp.HeaderTracestate)
+		ctx = chttp.WithTraceContext(ctx, parent)
+	}
+
+	_, span := ws.Option.Tracer.Start(ctx, "WEBSOCKET "+request.Path)
+	defer span.End()
+
+	span.SetAttribute("http.request.method", request.Method)
+	span.SetAttribute("url.path", request.Path)
+
+	handshake, err := ws.upgrader().UpgradeFromRequest(conn, &request)
+	if err != nil {
+		var handshakeErr *cwebsocket.HandshakeError
+		if errors.As(err, &handshakeErr) {
+			span.SetAttribute("http.response.status_code", handshakeErr.Code)
+		}
+
+		span.RecordError(err)
+		span.SetStatus(chttp.SpanStatusError, err.Error())
+
+		return nil, err
+	}
+
+	span.SetAttribute("http.response.status_code", 101)
+
+	return handshake, nil
+}
+
+// connect to a websocket server, the frames that are sent by the client are masked
+func Dial(ctx context.Context, url string, option *WSOption, dialOption *cwebsocket.DialOption) (client Client, err error) {
+	if option == nil {
+		option = &DefaultWSOption
+	}
+
+	conn, handshake, err := cwebsocket.Dial(ctx, url, dialOption)
+	if err != nil {
+		return Client{}, err
+	}
+
+	return newClient(conn, option, handshake, true), nil
 }
 
 func (client *Client) Send(msg string) error {
-	return cwebsocket.WriteString(client.Conn, msg)
+	return client.write([]byte(msg), cwebsocket.TEXT)
 }
 
+// send a binary message, use [Client.Send] for text
 func (client *Client) SendBytes(msg []byte) error {
-	return cwebsocket.Write(client.Conn, msg)
+	return client.write(msg, cwebsocket.BINARY)
 }
 
 func (client *Client) SendWithMessageType(msg string, messageType cwebsocket.MessageType) error {
-	return cwebsocket.WriteWithMessageType(client.Conn, msg, messageType)
+	return client.write([]byte(msg), messageType)
 }
 
-func (client *Client) Read() ([]byte, error) {
-	buf := make([]byte, client.option.MsgMaxSize)
+func (client *Client) write(msg []byte, messageType cwebsocket.MessageType) error {
+	if dataMessage(messageType) {
+		client.state.messageMu.Lock()
+		defer client.state.messageMu.Unlock()
+	}
 
-	n, err := client.Conn.Read(buf)
-	if err != nil {
-		return nil, cwebsocket.NewWsError("Error reading message : ", err.Error())
+	client.state.writeMu.Lock()
+	defer client.state.writeMu.Unlock()
+
+	var frame []byte
+	switch {
+	case client.deflate != nil:
+		frame = client.deflate.EncodeFrame(msg, messageType, client.mask)
+	case client.mask:
+		frame = cwebsocket.EncodeMaskedFrame(msg, messageType)
+	default:
+		frame = cwebsocket.EncodeFrame(msg, messageType)
 	}
 
-	f, err := cwebsocket.DecodeFrame(buf[:n])
+	err := cwebsocket.WriteFrame(frameWriter{client}, frame)
 	if err != nil {
-		return nil, cwebsocket.NewWsError("Error decoding frame : ", err.Error())
+		if errors.Is(err, ErrClientClosed) {
+			return err
+		}
+
+		return cwebsocket.NewWsError("Error sending message : ", err.Error())
+	}
+
+	if messageType == cwebsocket.CLOSE {
+		client.state.closeWritten = true
+	}
+
+	return nil
+}
+
+// write the frame with the write deadline, it must be called with the write lock
+func (client *Client) writeFrame(frame []byte) (int, error) {
+	if client.state.closeWritten {
+		return 0, ErrClientClosed
+	}
+
+	client.setWriteDeadline()
+
+	n, err := client.Conn.Write(frame)
+
+	// the rest of the frame is not written, so the connection can not be used anymore
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		client.shutdown()
 	}
 
-	// check if close signal
-	if f.Opcode == 0x8 {
-		return nil, cwebsocket.NewWsError("Close signal received", "")
+	return n, err
+}
+
+// the deadline of the next frame, it must be called with the write lock
+func (client *Client) setWriteDeadline() {
+	if client.option.WriteTimeout <= 0 {
+		return
+	}
+
+	if conn, ok := client.Conn.(interface{ SetWriteDeadline(t time.Time) error }); ok {
+		conn.SetWriteDeadline(time.Now().Add(client.option.WriteTimeout))
 	}
+}
+
+// read the payload of the next message, see [Client.ReadMessage]
+func (client *Client) Read() ([]byte, error) {
+	_, msg, err := client.ReadMessage()
 
-	return f.Payload, nil
+	return msg, err
 }
 
-func (client *Client) Close(reason string, code int) error {
-	return cwebsocket.Close(client.Conn, reason, code)
+// read the next text or binary message, fragmented message is joined into one payload.
+// ping is answered with pong automatically, close is answered and returned as [cwebsocket.CloseError]
+func (client *Client) ReadMessage() (cwebsocket.MessageType, []byte, error) {
+	client.state.reading.Add(1)
+	defer client.state.reading.Add(-1)
+
+	for {
+		messageType, msg, err := client.reader.ReadMessage()
+		if err != nil {
+			var wsErr *cwebsocket.WsError
+			if !errors.As(err, &wsErr) {
+				// the peer is gone or the read deadline is exceeded without pong, the writer
+				// and the pinger are stopped with the connection
+				client.shutdown()
+
+				return 0, nil, cwebsocket.NewWsError("Error reading message : ", err.Error())
+			}
+
+			// the rest of the frame is still unread, so the connection can not be used anymore
+			if wsErr.Code != 0 {
+				client.fail(wsErr)
+			}
+
+			return 0, nil, wsErr
+		}
+
+		switch messageType {
+		case cwebsocket.PING, cwebsocket.PONG:
+			client.handleControl(messageType, msg)
+			continue
+		case cwebsocket.CLOSE:
+			return 0, nil, client.receiveClose(msg)
+		}
+
+		return messageType, msg, nil
+	}
+}
+
+// writer for the next message, the message is sent in fragments while it is written
+// and it is finished when the writer is closed. other text or binary messages wait
+// from the first Write until Close, so the writer must always be closed
+func (client *Client) NextWriter(messageType cwebsocket.MessageType) io.WriteCloser {
+	var mw io.WriteCloser
+
+	w := lockedWriter{client}
+	switch {
+	case client.deflate != nil:
+		mw = client.deflate.NewMessageWriter(w, messageType, client.option.FragmentSize, client.mask)
+	case client.mask:
+		mw = cwebsocket.NewMaskedMessageWriter(w, messageType, client.option.FragmentSize)
+	default:
+		mw = cwebsocket.NewMessageWriter(w, messageType, client.option.FragmentSize)
+	}
+
+	// control message is a single frame on Close
+	if !dataMessage(messageType) {
+		return mw
+	}
+
+	return &messageWriter{WriteCloser: mw, state: client.state}
+}
+
+func dataMessage(messageType cwebsocket.MessageType) bool {
+	return messageType == cwebsocket.TEXT || messageType == cwebsocket.BINARY
+}
+
+// hold the message lock of the client from the first Write until Close
+type messageWriter struct {
+	io.WriteCloser
+	state  *clientState
+	locked bool
+	closed bool
+}
+
+func (w *messageWriter) lock() {
+	if !w.locked && !w.closed {
+		w.state.messageMu.Lock()
+		w.locked = true
+	}
+}
+
+func (w *messageWriter) Write(p []byte) (int, error) {
+	w.lock()
+
+	return w.WriteCloser.Write(p)
+}
+
+func (w *messageWriter) Close() error {
+	w.lock()
+
+	err := w.WriteCloser.Close()
+
+	if w.locked {
+		w.locked = false
+		w.state.messageMu.Unlock()
+	}
+	w.closed = true
+
+	return err
+}
+
+// writer of the encoded frames, it must be used with the write lock
+type frameWriter struct {
+	client *Client
+}
+
+func (w frameWriter) Write(p []byte) (int, error) {
+	return w.client.writeFrame(p)
+}
+
+// every fragment is written with the write lock, so control frames can be sent between the fragments
+type lockedWriter struct {
+	client *Client
+}
+
+func (w lockedWriter) Write(p []byte) (int, error) {
+	w.client.state.writeMu.Lock()
+	defer w.client.state.writeMu.Unlock()
+
+	return w.client.writeFrame(p)
 }